@@ -0,0 +1,12 @@
+package main
+
+import (
+	"log"
+	"os"
+	"github.com/AlexHalogen/RSFileProtect/internal/cli"
+)
+
+func main() {
+	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+	os.Exit(cli.Main(os.Args[1:]))
+}
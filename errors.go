@@ -0,0 +1,48 @@
+package rsfileprotect
+
+import (
+	"errors"
+	"fmt"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+)
+
+/*
+Errors that are not I/O errors wrap one of these, so that they can be told
+apart with errors.Is.
+*/
+var (
+	// ErrInvalidOptions wraps errors about options or damage lists given by callers
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrBadHeader wraps errors reading the metadata of an ecc file
+	ErrBadHeader = filehelper.ErrBadHeader
+	// ErrIncomplete is returned for ecc files whose encoding never finished
+	ErrIncomplete = filehelper.ErrIncomplete
+	// ErrGeometryMismatch wraps errors about ecc and crc files that are shorter than their metadata requires
+	ErrGeometryMismatch = filehelper.ErrGeometryMismatch
+	// ErrUnrecoverableSection is wrapped by *UnrecoverableError
+	ErrUnrecoverableSection = decoding.ErrUnrecoverableSection
+	// ErrNotVerified is returned when a repaired file still does not match its ecc file
+	ErrNotVerified = errors.New("repaired file failed verification")
+)
+
+// UnrecoverableError lists the sections that are damaged beyond repair
+type UnrecoverableError struct {
+	Sections []int
+}
+
+func (e *UnrecoverableError) Error() string {
+	return fmt.Sprintf("%d sections damaged beyond repair, first one is %d", len(e.Sections), e.Sections[0])
+}
+
+func (e *UnrecoverableError) Unwrap() error {
+	return ErrUnrecoverableSection
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+}
+
+func badHeader(name string, err error) error {
+	return fmt.Errorf("%s: %w", name, err)
+}
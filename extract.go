@@ -0,0 +1,108 @@
+package rsfileprotect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+)
+
+type ExtractOptions struct {
+	EccPath 	string // <path>.ecc if empty
+	CRCPath 	string // <ecc>.crc if empty
+	Salvage 	*SalvageOptions // off if nil
+	Progress 	func(Progress) // called after every section, off if nil
+	Log 		func(LogEvent) // receives damaged chunks and rebuilt sections, silent if nil
+}
+
+type ExtractResult struct {
+	Written 	int64 // bytes written to out
+	Repaired 	[]int // sections rebuilt from ecc chunks on the way
+}
+
+/**
+ * Extract writes length bytes of the file at path from offset on to out,
+ * reading only the sections that hold them. The sections are checked and
+ * rebuilt in memory where damaged, as by a Reader, so the files are left as
+ * they are. A negative length extracts up to the end of the file. Sections
+ * that cannot be rebuilt are written as zeros and reported by an
+ * *UnrecoverableError; the result is returned along with it.
+ */
+func Extract(ctx context.Context, path string, out io.Writer, offset, length int64, opts ExtractOptions) (*ExtractResult, error) {
+	sidecars(path, &opts.EccPath, &opts.CRCPath)
+	fs, closeAll, err := openFiles(path, opts.EccPath, opts.CRCPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll()
+	return fs.extract(ctx, out, offset, length, &opts)
+}
+
+// ExtractReaderAt is Extract for data that is not in a file of its own, see ScanReaderAt
+func ExtractReaderAt(ctx context.Context, data, ecc, crc io.ReaderAt, out io.Writer, offset, length int64, opts ExtractOptions) (*ExtractResult, error) {
+	fs, err := newFileSet("ecc file", data, ecc, crc)
+	if err != nil {
+		return nil, err
+	}
+	return fs.extract(ctx, out, offset, length, &opts)
+}
+
+func (fs *fileSet) extract(ctx context.Context, out io.Writer, offset, length int64, opts *ExtractOptions) (*ExtractResult, error) {
+	size := fs.meta.FileSize
+	if offset < 0 || offset > size {
+		return nil, invalidf("offset %d outside of the file, which has %d bytes", offset, size)
+	}
+	if length < 0 {
+		length = size - offset
+	}
+	if length > size-offset {
+		return nil, invalidf("range of %d bytes from %d past the end of the file, which has %d bytes", length, offset, size)
+	}
+	r, err := fs.reader(ReaderOptions{Salvage: opts.Salvage, Cache: -1, Log: opts.Log})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ExtractResult{}
+	tracker := progress.NewTracker(progressFunc(opts.Progress), length)
+	defer tracker.Finish()
+	sectionSize := fs.meta.SectionSize()
+	buffer := make([]byte, sectionSize)
+	var lost []int
+	end := offset + length
+	for pos := offset; pos < end; {
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		section := int(pos / sectionSize)
+		n := (int64(section)+1)*sectionSize - pos
+		if n > end-pos {
+			n = end-pos
+		}
+		_, err := r.ReadAt(buffer[:n], pos)
+		var ue *UnrecoverableError
+		if errors.As(err, &ue) {
+			for i := range buffer[:n] {
+				buffer[i] = 0
+			}
+			lost = append(lost, section)
+		} else if err != nil && err != io.EOF {
+			return res, err
+		}
+		tracker.IODone()
+		if _, err := out.Write(buffer[:n]); err != nil {
+			return res, err
+		}
+		res.Written += n
+		pos += n
+		tracker.Advance(pos - offset)
+	}
+
+	for _, d := range r.Repaired() {
+		res.Repaired = append(res.Repaired, d.Section)
+	}
+	if len(lost) != 0 {
+		return res, &UnrecoverableError{lost}
+	}
+	return res, nil
+}
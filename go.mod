@@ -1,4 +1,4 @@
-module alexhalogen/rsfileprotect
+module github.com/AlexHalogen/RSFileProtect
 
 go 1.13
 
@@ -0,0 +1,85 @@
+package rsfileprotect
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// ChunkCRCSize is the size of the crc32 the crc file keeps for every chunk
+const ChunkCRCSize = 4
+
+// SectionRun is a run of consecutive sections sharing one level
+type SectionRun struct {
+	First 	int
+	Count 	int
+	Level 	int
+}
+
+// Info describes an ecc file, see ReadInfo
+type Info struct {
+	FileSize 		int64 // of the protected file
+	BlockSize 		int
+	NumData 		int // data chunks per section
+	NumRecovery 	int // ecc chunks per section outside of regions
+	Regions 		[]Region
+	Created 		time.Time // zero if not recorded
+	Creator 		string
+	DataName 		string // base name of the protected file when it was encoded
+	Digest 			string // sha256 of the data in hex, only recorded for streams; empty if not recorded
+	Fingerprint 	string // identifies the metadata, e.g. to match reports
+	TrailerVersion 	int // 0 for legacy files without a trailer
+	TrailerSize 	int64
+
+	Sections 		int
+	EccChunks 		int
+	Runs 			[]SectionRun
+	EccSize 		int64 // expected sizes of the sidecars
+	CRCSize 		int64
+}
+
+/**
+ * ReadInfo reads the metadata of an ecc file and derives its geometry. It
+ * returns ErrIncomplete if the encoding that wrote the file did not finish.
+ */
+func ReadInfo(eccPath string) (*Info, error) {
+	f, err := os.Open(eccPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	layout, err := filehelper.ReadLayout(f)
+	if err != nil {
+		return nil, badHeader(eccPath, err)
+	}
+	var meta types.Metadata
+	if err := filehelper.ReadMeta(f, &meta); err != nil {
+		return nil, badHeader(eccPath, err)
+	}
+	if meta.BlockSize <= 0 || meta.NumData == 0 || meta.FileSize < 0 {
+		return nil, badHeader(eccPath, errors.New("invalid geometry"))
+	}
+
+	info := &Info{FileSize: meta.FileSize, BlockSize: int(meta.BlockSize), NumData: int(meta.NumData),
+		NumRecovery: int(meta.NumRecovery), Regions: fromRegions(meta.Regions),
+		Creator: meta.Creator, DataName: meta.DataName, Fingerprint: filehelper.Fingerprint(&meta),
+		TrailerVersion: layout.TrailerVersion, TrailerSize: layout.TrailerSize}
+	if meta.Digest != [32]byte{} {
+		info.Digest = hex.EncodeToString(meta.Digest[:])
+	}
+	if meta.Created != 0 {
+		info.Created = time.Unix(meta.Created, 0)
+	}
+	info.Sections = meta.NumSections()
+	info.EccChunks = meta.EccChunks()
+	for _, r := range meta.Runs() {
+		info.Runs = append(info.Runs, SectionRun{r.First, r.Count, r.NumRecovery})
+	}
+	dataChunks := int64(info.Sections) * int64(meta.NumData)
+	info.EccSize = filehelper.HeaderSize + int64(info.EccChunks)*int64(meta.BlockSize) + layout.TrailerSize
+	info.CRCSize = (dataChunks + int64(info.EccChunks)) * ChunkCRCSize
+	return info, nil
+}
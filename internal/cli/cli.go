@@ -0,0 +1,126 @@
+// Package cli implements the command-line front ends: the legacy encoder and
+// decoder binaries and the unified rsprotect command share the code here.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+)
+
+const (
+	ExitClean = 0
+	ExitError = 1 // usage or I/O error
+	ExitRepairable = 2 // damage found, all of it repairable
+	ExitRepaired = 3
+	ExitUnrecoverable = 4 // damage beyond repair, or repair failed
+	ExitInterrupted = 130 // stopped by SIGINT or SIGTERM
+)
+
+// verbosity is the lowest level of messages printed, see setVerbosity
+var verbosity = logging.Info
+
+func verbosityFlags(set *flag.FlagSet, quiet, verbose *bool) {
+	set.BoolVar(quiet, "q", false, "quiet, only print warnings and errors")
+	set.BoolVar(verbose, "v", false, "verbose, also print every damaged chunk")
+}
+
+// setVerbosity applies -q and -v to the messages of the cli and, through logAPI, the library
+func setVerbosity(quiet, verbose bool) {
+	switch {
+		case verbose:
+			verbosity = logging.Debug
+		case quiet:
+			verbosity = logging.Warn
+		default:
+			verbosity = logging.Info
+	}
+}
+
+// infof prints messages that -q silences
+func infof(format string, args ...interface{}) {
+	if verbosity <= logging.Info {
+		log.Printf(format, args...)
+	}
+}
+
+// logAPI prints messages from the rsfileprotect package
+func logAPI(e rsfileprotect.LogEvent) {
+	if logging.Level(e.Level) >= verbosity {
+		log.Println(e)
+	}
+}
+
+// findSidecars fills in missing ecc and crc names following the encoder's naming
+func findSidecars(data string, ecc, crc *string) {
+	if *ecc == "" && data != "" {
+		*ecc = rsfileprotect.EccPathFor(data)
+	}
+	if *crc == "" && *ecc != "" {
+		*crc = rsfileprotect.CRCPathFor(*ecc)
+	}
+}
+
+/**
+ * Parses flags that may come before or after a single positional file name,
+ * e.g. "verify -salvage file -ecc x". The file name, if any, is stored in file.
+ */
+func parseWithFile(set *flag.FlagSet, args []string, file *string) error {
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() == 0 {
+		return nil
+	}
+	if *file != "" {
+		return fmt.Errorf("file given both as argument and flag")
+	}
+	*file = set.Arg(0)
+	if err := set.Parse(set.Args()[1:]); err != nil {
+		return err
+	}
+	if set.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", set.Args())
+	}
+	return nil
+}
+
+/**
+ * Returns a context canceled on the first SIGINT or SIGTERM so that library
+ * calls stop between sections. A second signal terminates the process as usual.
+ */
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+			case s := <-sig:
+				log.Printf("Received %v, stopping\n", s)
+				signal.Stop(sig)
+				cancel()
+			case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// removeIncomplete deletes files left unusable by an interrupted or failed run
+func removeIncomplete(names ...string) {
+	for _, name := range names {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Incomplete file %s could not be removed: %v\n", name, err)
+			continue
+		}
+		infof("Removed incomplete %s\n", name)
+	}
+}
@@ -0,0 +1,586 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/cmdparser"
+	"github.com/AlexHalogen/RSFileProtect/internal/report"
+)
+
+type decodeArgs struct {
+	action string // a: auto repair, m: manual repair, s: scan
+	showHelp bool
+	ecc string
+	crc string
+	data string
+	eccDmgIdxs, dataDmgIdxs string
+	report string
+	output string
+	salvage bool
+	retries, sectorSize int
+	ddrescue, badblocks string
+	badblocksSize int64
+	format string
+	verify bool
+	checkpoint string
+	quiet, verbose bool
+}
+
+/**
+ * Creates the flag set for an action. Besides the decoder's a, m and s,
+ * action r takes the flags of both a and m, as rsprotect repair does.
+ */
+func (d *decodeArgs) flags(name string, action string) *flag.FlagSet {
+	s := flag.NewFlagSet(name, flag.ContinueOnError)
+	s.StringVar(&d.ecc, "ecc", "", "ecc file containing code needed to restore file, <data>.ecc by default")
+	s.StringVar(&d.crc, "crc", "", "crc file for quick integrity check and restoration, <ecc>.crc by default")
+	s.StringVar(&d.data,"data", "", "required,  file needed to be verified or repaired")
+	s.BoolVar(&d.showHelp, "h", false, "Prints this help message")
+	s.BoolVar(&d.salvage, "salvage", false, "retry unreadable chunks and fall back to sector-sized reads, for data on failing media")
+	s.IntVar(&d.retries, "retries", 3, "number of retries of failed reads in salvage mode")
+	s.IntVar(&d.sectorSize, "sector", 512, "size of reads used in salvage mode after a chunk keeps failing")
+	s.StringVar(&d.ddrescue, "ddrescue", "", "GNU ddrescue mapfile of the data file, chunks not rescued are treated as damaged")
+	s.StringVar(&d.badblocks, "badblocks", "", "list of bad blocks of the data file as written by badblocks")
+	s.Int64Var(&d.badblocksSize, "bbsize", 1024, "block size used by badblocks")
+	s.StringVar(&d.format, "format", "text", "output format, text or json; json results are written to stdout")
+	verbosityFlags(s, &d.quiet, &d.verbose)
+
+	switch action {
+		case "a", "m", "r":
+			s.StringVar(&d.output, "out", "", "required, file name of repaired file")
+			s.BoolVar(&d.verify, "verify", true, "scan the repaired file afterwards")
+	}
+	switch action {
+		case "a", "s", "r":
+			s.StringVar(&d.checkpoint, "checkpoint", "", "save the scan position to this file from time to time and on interrupt, and resume from it")
+	}
+	switch action {
+		case "m", "r":
+			s.StringVar(&d.eccDmgIdxs, "edmg", "", "ecc damages as comma-separated chunk indices, index ranges and hex byte offset ranges, e.g [1,15-20,0x8000-0x8fff]")
+			s.StringVar(&d.dataDmgIdxs, "ddmg", "", "data damages as comma-separated chunk indices, index ranges and hex byte offset ranges, e.g [1,15-20,0x8000-0x8fff]")
+			s.StringVar(&d.report, "report", "", "damage report written by a scan, possibly edited; combined with -ddmg and -edmg")
+		case "s":
+			s.StringVar(&d.report, "report", "", "write a damage report for use with manual repairs to this file")
+	}
+	return s
+}
+
+/**
+ * Runs the decoder with the legacy arguments, e.g.
+ * decoder <a|s|m> -data file [-ecc file.ecc] [-crc file.ecc.crc] ...
+ */
+func Decoder(args []string) int {
+	var d decodeArgs
+	sets := []*flag.FlagSet{d.flags("a", "a"), d.flags("s", "s"), d.flags("m", "m")}
+	usage := func() {
+		output := sets[0].Output()
+
+		fmt.Fprintf(output, "\nCommand usage:\n  %s <action> <Args...> [-h]\n", "decoder")
+		fmt.Fprintf(output, "\nActions: \n")
+		fmt.Fprintf(output, "  a  Automatically scan and repairs the file if damaged\n")
+		fmt.Fprintf(output, "  s  Scan the file and report damaged chunks in formats tha can be used for manual repairs; Reports nothing to stdout if no errors were found\n")
+		fmt.Fprintf(output, "  m  Repair damaged file with user-provided damage positions\n")
+		printExitCodes(output)
+
+		for _, s := range sets {
+			fmt.Fprintf(output, "\nArguments for action %s:\n", s.Name())
+			s.PrintDefaults()
+		}
+	}
+	for _, s := range sets {
+		s.Usage = func() {}
+	}
+
+	if len(args) < 1 {
+		usage()
+		return ExitError
+	}
+	d.action = args[0]
+	var set *flag.FlagSet
+	for _, s := range sets {
+		if s.Name() == d.action {
+			set = s
+		}
+	}
+	if set == nil {
+		log.Printf("Unsupported action %s\n", d.action)
+		usage()
+		return ExitError
+	}
+	if err := set.Parse(args[1:]); err != nil || set.NArg() != 0 || !d.sanitize() {
+		usage()
+		return ExitError
+	}
+	return d.run()
+}
+
+// Verify runs "rsprotect verify [flags] FILE"
+func Verify(args []string) int {
+	d := decodeArgs{action: "s"}
+	return d.runCommand("verify", "rsprotect verify [-ecc file.ecc] [-crc file.ecc.crc] [-report file] FILE\n  rsprotect verify -ecc file.ecc [-crc file.ecc.crc] -   (data read from stdin)", args)
+}
+
+/**
+ * Repair runs "rsprotect repair [flags] FILE". Damage positions given with
+ * -ddmg, -edmg or -report are repaired as such, otherwise the file is scanned
+ * first. The result goes to <FILE>.repaired unless -out is given.
+ */
+func Repair(args []string) int {
+	d := decodeArgs{action: "r"}
+	return d.runCommand("repair", "rsprotect repair [-out file] [-ddmg list] [-edmg list] [-report file] FILE", args)
+}
+
+func (d *decodeArgs) runCommand(name, synopsis string, args []string) int {
+	set := d.flags(name, d.action)
+	set.Usage = func() {
+		fmt.Fprintf(set.Output(), "Command usage:\n  %s\n", synopsis)
+		set.PrintDefaults()
+		printExitCodes(set.Output())
+	}
+	if err := parseWithFile(set, args, &d.data); err != nil {
+		log.Println(err)
+		set.Usage()
+		return ExitError
+	}
+	if d.action == "r" {
+		d.action = "a"
+		if d.eccDmgIdxs != "" || d.dataDmgIdxs != "" || d.report != "" {
+			d.action = "m"
+		}
+		if d.output == "" && d.data != "" {
+			d.output = d.data + ".repaired"
+		}
+	}
+	if !d.sanitize() {
+		set.Usage()
+		return ExitError
+	}
+	return d.run()
+}
+
+func printExitCodes(output io.Writer) {
+	fmt.Fprintf(output, "\nExit codes:\n")
+	fmt.Fprintf(output, "  %d  No damage found\n", ExitClean)
+	fmt.Fprintf(output, "  %d  Usage or I/O error\n", ExitError)
+	fmt.Fprintf(output, "  %d  Damage found, all of it repairable\n", ExitRepairable)
+	fmt.Fprintf(output, "  %d  Damage repaired\n", ExitRepaired)
+	fmt.Fprintf(output, "  %d  Damage beyond repair, or the repair failed partially\n", ExitUnrecoverable)
+	fmt.Fprintf(output, "  %d  Interrupted, incomplete repair outputs are removed\n", ExitInterrupted)
+}
+
+func (d *decodeArgs) sanitize() bool {
+	if d.showHelp {
+		return false
+	}
+	if d.data == "" {
+		log.Println("No data file given")
+		return false
+	}
+	if d.data == "-" && !d.sanitizeStream() {
+		return false
+	}
+	findSidecars(d.data, &d.ecc, &d.crc)
+
+	switch d.action {
+		case "a": // auto repair
+			if d.output == "" { // no output file
+				return false
+			}
+		case "m": // manual repair, damage positions needed
+			if d.eccDmgIdxs == "" && d.dataDmgIdxs == "" && d.report == "" || d.output == "" { // all missing
+				return false
+			}
+	}
+	if d.badblocksSize <= 0 {
+		log.Printf("Invalid -bbsize %d, the block size must be positive\n", d.badblocksSize)
+		return false
+	}
+	if d.format != "text" && d.format != "json" {
+		log.Printf("Unsupported format %s\n", d.format)
+		return false
+	}
+	return true
+}
+
+// sanitizeStream checks the flags for data read from stdin, which can only be verified
+func (d *decodeArgs) sanitizeStream() bool {
+	if d.action != "s" {
+		log.Println("Data read from stdin can only be verified")
+		return false
+	}
+	if d.ecc == "" {
+		log.Println("Data read from stdin needs -ecc")
+		return false
+	}
+	if d.checkpoint != "" || d.salvage || d.ddrescue != "" || d.badblocks != "" {
+		log.Println("-checkpoint, -salvage, -ddrescue and -badblocks need a data file")
+		return false
+	}
+	return true
+}
+
+func (d *decodeArgs) run() int {
+	action := d.action
+	setVerbosity(d.quiet, d.verbose)
+	ctx, stop := interruptible()
+	defer stop()
+
+	stream := d.data == "-"
+	if !stream && !exists(d.data) {
+		// every data chunk becomes an erasure, rebuild as much as the ecc allows
+		log.Printf("Data file %s not found, treating all data as damaged\n", d.data)
+	}
+	eccFile, err := os.Open(d.ecc)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	meta := readMeta(eccFile)
+	eccFile.Close()
+	if meta == nil {
+		return ExitError
+	}
+	infof("Data: %s, ECC: %s, CRC: %s\n", d.data, d.ecc, d.crc)
+	infof("Metadata: File Size: %d, Chunk size: %d, #Data: %d, #Recovery: %d", meta.FileSize, meta.BlockSize, meta.NumData, meta.NumRecovery)
+	for _, r := range meta.Regions {
+		infof("Region: [%d, %d), #Recovery: %d", r.Start, r.End, r.NumRecovery)
+	}
+
+	var knownBad []rsfileprotect.ByteRange
+	if !d.loadBadMap(&knownBad) {
+		return ExitError
+	}
+	var salvage *rsfileprotect.SalvageOptions
+	if d.salvage {
+		salvage = &rsfileprotect.SalvageOptions{Retries: d.retries, SectorSize: d.sectorSize}
+		if d.retries == 0 {
+			salvage.Retries = -1 // zero means the default to the library
+		}
+	}
+
+	var damages []decoding.DamageDesc
+	var sizeDiff int64
+	if action == "m" {
+		// positions can only be checked once the geometry is known
+		dataDmgIdx, err := cmdparser.ParseChunkList(d.dataDmgIdxs, meta, false)
+		if err != nil {
+			log.Println(err)
+			return ExitError
+		}
+		eccDmgIdx, err := cmdparser.ParseChunkList(d.eccDmgIdxs, meta, true)
+		if err != nil {
+			log.Println(err)
+			return ExitError
+		}
+		damages = cmdparser.CSVToDamage(meta, dataDmgIdx, eccDmgIdx)
+		if d.report != "" {
+			fromReport, ok := d.readReport(meta)
+			if !ok {
+				return ExitError
+			}
+			damages = decoding.MergeDamages(damages, fromReport)
+		}
+	} else {
+		opts := rsfileprotect.ScanOptions{EccPath: d.ecc, CRCPath: d.crc, Salvage: salvage, KnownBad: knownBad,
+			Progress: newProgressView("scan").ReportAPI, Log: logAPI}
+		first, saved, ok := d.loadCheckpoint(meta)
+		if !ok {
+			return ExitError
+		}
+		opts.FirstSection = first
+		if d.checkpoint != "" {
+			opts.Checkpoint = func(sections int, found []rsfileprotect.Damage) {
+				d.saveCheckpoint(meta, decoding.MergeDamages(saved, toDesc(found)), sections)
+			}
+		}
+		var scan *rsfileprotect.ScanResult
+		if stream {
+			scan, err = rsfileprotect.ScanStream(ctx, readerContext{ctx, os.Stdin}, opts)
+		} else {
+			scan, err = rsfileprotect.Scan(ctx, d.data, opts)
+		}
+		if ctx.Err() != nil {
+			if d.checkpoint != "" {
+				log.Printf("Scan position saved to %s\n", d.checkpoint)
+			}
+			return ExitInterrupted
+		}
+		if err != nil {
+			log.Println(err)
+			log.Printf("Severe error prevented repair of file %s\n", d.data)
+			return ExitError
+		}
+		if d.checkpoint != "" {
+			os.Remove(d.checkpoint)
+		}
+		if d.salvage {
+			defer d.reportUnreadable(scan.Unreadable)
+		}
+		// known bad ranges are among the damages already, also for sections before the checkpoint
+		damages = decoding.MergeDamages(saved, toDesc(scan.Damages))
+		sizeDiff = scan.SizeDiff
+	}
+
+	var result report.Output
+	if action != "m" {
+		result.Scan = report.NewScanResult(d.data, meta, damages, sizeDiff)
+	}
+
+	if action == "s" && d.format == "text" {
+		sd, se := cmdparser.DamageToCSV(damages, meta)
+		if len(*sd) != 0 || len(*se) != 0 {
+			fmt.Printf("%s: Data=[%s] ECC=[%s]\n", d.data, *sd, *se)
+		}
+		if sizeDiff != 0 {
+			fmt.Printf("%s: Size=%d Expected=%d\n", d.data, meta.FileSize+sizeDiff, meta.FileSize)
+		}
+	}
+	if action == "s" && d.report != "" && !d.writeReport(meta, damages) {
+		return ExitError
+	}
+
+	if action == "m" || action == "a" && (len(damages) > 0 || sizeDiff != 0) {
+		// for given damages the library tells whether there is anything to repair, the size included
+		opts := rsfileprotect.RepairOptions{EccPath: d.ecc, CRCPath: d.crc, Damages: fromDesc(damages),
+			Salvage: salvage, KnownBad: knownBad, Verify: d.verify, Log: logAPI}
+		if d.verify {
+			opts.Progress = newProgressView("verify").ReportAPI // repairs of given damages report no progress
+		}
+		var code int
+		if result.Repair, code = d.repair(ctx, damages, opts); code != ExitClean {
+			return code
+		}
+	}
+
+	if d.format == "json" {
+		if err := result.Write(os.Stdout); err != nil {
+			log.Println(err)
+			return ExitError
+		}
+	}
+	return exitCode(&result)
+}
+
+/**
+ * Writes the repaired file and returns its part of the report, nil if there
+ * was nothing to repair. An exit code other than ExitClean ends the run.
+ */
+func (d *decodeArgs) repair(ctx context.Context, damages []decoding.DamageDesc, opts rsfileprotect.RepairOptions) (*report.RepairResult, int) {
+	res, err := rsfileprotect.Repair(ctx, d.data, d.output, opts)
+	if ctx.Err() != nil {
+		removeIncomplete(d.output)
+		return nil, ExitInterrupted
+	}
+	if res == nil {
+		log.Printf("Failed to repair %s into %s: %v\n", d.data, d.output, err)
+		return nil, ExitError
+	}
+	if !res.Written {
+		infof("Nothing to repair in %s\n", d.data)
+		return nil, ExitClean
+	}
+	// a repair that does not verify is complete all the same
+	repaired := err == nil || errors.Is(err, rsfileprotect.ErrNotVerified)
+	if repaired {
+		infof("Successfully repaired %s\n", d.data)
+	} else {
+		log.Println(err)
+		log.Printf("File reconstruction failed, partial result saved")
+		log.Printf("Repaired sections: %v", res.Repaired)
+	}
+	rep := report.NewRepairResult(d.output, damages, res.Repaired, repaired)
+	if opts.Verify && repaired {
+		if res.Verified {
+			infof("Verified %s\n", d.output)
+		} else {
+			log.Printf("Verification of %s failed\n", d.output)
+		}
+		rep.Verified = &res.Verified
+	}
+	return rep, ExitClean
+}
+
+// toDesc converts damages found by a scan for the report formats
+func toDesc(damages []rsfileprotect.Damage) []decoding.DamageDesc {
+	res := make([]decoding.DamageDesc, 0, len(damages))
+	for _, d := range damages {
+		desc := decoding.DamageDesc{Section: d.Section, DataDamage: d.Data, EccDamage: d.Ecc}
+		for _, c := range d.Chunks {
+			desc.Details = append(desc.Details, decoding.ChunkDamage{Ecc: c.Ecc, Index: c.Index, Expected: c.Expected, Actual: c.Actual, Reason: c.Reason})
+		}
+		res = append(res, desc)
+	}
+	return res
+}
+
+// fromDesc converts damages from the report formats for a repair
+func fromDesc(damages []decoding.DamageDesc) []rsfileprotect.Damage {
+	res := make([]rsfileprotect.Damage, 0, len(damages))
+	for _, d := range damages {
+		res = append(res, rsfileprotect.Damage{Section: d.Section, Data: d.DataDamage, Ecc: d.EccDamage})
+	}
+	return res
+}
+
+func exitCode(result *report.Output) int {
+	if r := result.Repair; r != nil {
+		if !r.Success || len(r.Failed) != 0 || (r.Verified != nil && !*r.Verified) {
+			return ExitUnrecoverable
+		}
+		return ExitRepaired
+	}
+	if s := result.Scan; s != nil && !s.Clean {
+		if !s.Repairable {
+			return ExitUnrecoverable
+		}
+		return ExitRepairable
+	}
+	return ExitClean
+}
+
+
+func readMeta(eccFile io.ReaderAt) *types.Metadata {
+	var fmeta types.Metadata;
+	metaErr := filehelper.ReadMeta(eccFile, &fmeta)
+	if metaErr != nil {
+		log.Println(metaErr)
+		log.Println("Failed to read metadata from ecc file!")
+		return nil
+	}
+	return &fmeta // ok to do this in go...
+}
+
+
+
+func (d *decodeArgs) writeReport(meta *types.Metadata, damages []decoding.DamageDesc) bool {
+	f, err := os.Create(d.report)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer f.Close()
+	if err := report.Write(f, d.data, meta, damages); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (d *decodeArgs) readReport(meta *types.Metadata) ([]decoding.DamageDesc, bool) {
+	f, err := os.Open(d.report)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	defer f.Close()
+	rep, err := report.Read(f)
+	if err == nil {
+		var damages []decoding.DamageDesc
+		damages, err = rep.Damages(meta)
+		if err == nil {
+			return damages, true
+		}
+	}
+	log.Printf("%s: %v\n", d.report, err)
+	return nil, false
+}
+
+/**
+ * Reads the scan checkpoint, if any, and returns the section to continue at
+ * and the damages found before it
+ */
+func (d *decodeArgs) loadCheckpoint(meta *types.Metadata) (int, []decoding.DamageDesc, bool) {
+	if d.checkpoint == "" || !exists(d.checkpoint) {
+		return 0, nil, true
+	}
+	f, err := os.Open(d.checkpoint)
+	if err != nil {
+		log.Println(err)
+		return 0, nil, false
+	}
+	defer f.Close()
+	rep, err := report.Read(f)
+	var damages []decoding.DamageDesc
+	if err == nil {
+		damages, err = rep.Damages(meta)
+	}
+	if err != nil {
+		log.Printf("%s: %v\n", d.checkpoint, err)
+		return 0, nil, false
+	}
+	if rep.Scanned == 0 {
+		log.Printf("%s is not a checkpoint of an interrupted scan\n", d.checkpoint)
+		return 0, nil, false
+	}
+	infof("Resuming scan at section %d of %d\n", rep.Scanned, meta.NumSections())
+	// details of earlier damages are lost, but they are repaired the same way
+	return rep.Scanned, damages, true
+}
+
+func (d *decodeArgs) saveCheckpoint(meta *types.Metadata, damages []decoding.DamageDesc, sections int) {
+	tmp := d.checkpoint + ".tmp"
+	f, err := os.Create(tmp)
+	if err == nil {
+		err = report.WritePartial(f, d.data, meta, damages, sections)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.checkpoint)
+	}
+	if err != nil {
+		log.Printf("Failed to save scan position: %v\n", err)
+	}
+}
+
+// loadBadMap reads the ranges of -ddrescue and -badblocks into knownBad
+func (d *decodeArgs) loadBadMap(knownBad *[]rsfileprotect.ByteRange) bool {
+	bad := &filehelper.BadMap{}
+	for _, src := range []struct{ name string; load func(*os.File) error }{
+		{d.ddrescue, func(f *os.File) error { return bad.ReadDdrescue(f) }},
+		{d.badblocks, func(f *os.File) error { return bad.ReadBadblocks(f, d.badblocksSize) }},
+	} {
+		if src.name == "" {
+			continue
+		}
+		f, err := os.Open(src.name)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		err = src.load(f)
+		f.Close()
+		if err != nil {
+			log.Printf("%s: %v\n", src.name, err)
+			return false
+		}
+	}
+	for _, r := range bad.Ranges {
+		*knownBad = append(*knownBad, rsfileprotect.ByteRange{Start: r.Start, End: r.End})
+	}
+	return true
+}
+
+func (d *decodeArgs) reportUnreadable(unreadable []rsfileprotect.ByteRange) {
+	if len(unreadable) == 0 {
+		return
+	}
+	var size int64
+	for _, r := range unreadable {
+		size += r.End - r.Start
+	}
+	log.Printf("%d bytes of %s could not be read:\n", size, d.data)
+	for _, r := range unreadable {
+		log.Printf("  0x%x-0x%x\n", r.Start, r.End-1)
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+type infoArgs struct {
+	data string
+	ecc string
+	crc string
+	showHelp bool
+}
+
+/**
+ * Info runs "rsprotect info [-ecc file.ecc] [-crc file.ecc.crc] FILE". FILE is
+ * either the data file or the ecc file itself. Besides the metadata it prints
+ * the derived geometry and checks the sizes of the sidecars and the data file.
+ */
+func Info(args []string) int {
+	var a infoArgs
+	set := flag.NewFlagSet("info", flag.ContinueOnError)
+	set.StringVar(&a.ecc, "ecc", "", "ecc file to inspect, <FILE>.ecc by default")
+	set.StringVar(&a.crc, "crc", "", "crc file to check, <ecc>.crc by default")
+	set.BoolVar(&a.showHelp, "h", false, "Prints this help message")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "Command usage:\n  rsprotect info [-ecc file.ecc] [-crc file.ecc.crc] FILE")
+		set.PrintDefaults()
+		fmt.Fprintln(set.Output(), "\nExits with 1 if the sidecars cannot be read or their sizes do not match the metadata.")
+	}
+	if err := parseWithFile(set, args, &a.data); err != nil {
+		log.Println(err)
+		set.Usage()
+		return ExitError
+	}
+	if a.showHelp || a.data == "" && a.ecc == "" {
+		set.Usage()
+		return ExitError
+	}
+	if a.ecc == "" && strings.HasSuffix(a.data, ".ecc") && !exists(rsfileprotect.EccPathFor(a.data)) {
+		// given the ecc file itself
+		a.ecc = a.data
+		a.data = strings.TrimSuffix(a.data, ".ecc")
+	}
+	findSidecars(a.data, &a.ecc, &a.crc)
+
+	eccFile, err := os.Open(a.ecc)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	defer eccFile.Close()
+	layout, err := filehelper.ReadLayout(eccFile)
+	if err != nil {
+		log.Printf("%s: %v\n", a.ecc, err)
+		return ExitError
+	}
+	meta := readMeta(eccFile)
+	if meta == nil {
+		if ckpt, err := filehelper.ReadCheckpoint(rsfileprotect.CheckpointPathFor(a.ecc)); err == nil {
+			fmt.Printf("ECC file:        %s\n", a.ecc)
+			fmt.Printf("Status:          incomplete, %d of %d sections encoded, protect resumes from %s\n",
+				ckpt.Sections, ckpt.Meta.NumSections(), rsfileprotect.CheckpointPathFor(a.ecc))
+		}
+		return ExitError
+	}
+
+	printMeta(a.ecc, meta, layout)
+	info, err := rsfileprotect.ReadInfo(a.ecc)
+	if err != nil {
+		fmt.Printf("\nChecks:\n  %-10s %v\n", "header", err)
+		return ExitError
+	}
+
+	fmt.Printf("\nGeometry:\n")
+	fmt.Printf("  Sections:      %d of %d bytes\n", info.Sections, meta.SectionSize())
+	fmt.Printf("  Data chunks:   %d, %d holding file contents\n", info.Sections*info.NumData, (info.FileSize+int64(info.BlockSize)-1)/int64(info.BlockSize))
+	fmt.Printf("  ECC chunks:    %d\n", info.EccChunks)
+	for _, r := range info.Runs {
+		fmt.Printf("  Sections %d-%d: %d ecc chunks each\n", r.First, r.First+r.Count-1, r.Level)
+	}
+	fmt.Printf("  ECC size:      %d\n", info.EccSize)
+	fmt.Printf("  CRC size:      %d\n", info.CRCSize)
+
+	fmt.Printf("\nChecks:\n")
+	ok := checkSize("ecc", a.ecc, info.EccSize)
+	ok = checkSize("crc", a.crc, info.CRCSize) && ok
+	if exists(a.data) {
+		ok = checkSize("data", a.data, meta.FileSize) && ok
+	}
+	if !ok {
+		return ExitError
+	}
+	return ExitClean
+}
+
+func printMeta(eccName string, meta *types.Metadata, layout *filehelper.Layout) {
+	fmt.Printf("ECC file:        %s\n", eccName)
+	if layout.TrailerVersion == 0 {
+		fmt.Printf("Format:          header only (legacy)\n")
+	} else {
+		fmt.Printf("Format:          header + trailer version %d (%d bytes)\n", layout.TrailerVersion, layout.TrailerSize)
+	}
+	if meta.StalePadding(meta.NumSections()-1) {
+		fmt.Printf("                 last section cannot be repaired, padding not recorded\n")
+	}
+	fmt.Printf("Hash:            crc32 (IEEE), %d bytes per chunk\n", rsfileprotect.ChunkCRCSize)
+	if meta.Created != 0 {
+		fmt.Printf("Created:         %s\n", time.Unix(meta.Created, 0).Format(time.RFC3339))
+	} else {
+		fmt.Printf("Created:         unknown\n")
+	}
+	if meta.Creator != "" {
+		fmt.Printf("Created by:      %s\n", meta.Creator)
+	}
+	if meta.DataName != "" {
+		fmt.Printf("Data file name:  %s\n", meta.DataName)
+	}
+	if meta.Digest != [32]byte{} {
+		fmt.Printf("SHA-256:         %s\n", hex.EncodeToString(meta.Digest[:]))
+	}
+	fmt.Printf("Fingerprint:     %s\n", filehelper.Fingerprint(meta))
+	fmt.Printf("File size:       %d\n", meta.FileSize)
+	fmt.Printf("Chunk size:      %d\n", meta.BlockSize)
+	fmt.Printf("#Data:           %d\n", meta.NumData)
+	fmt.Printf("#Recovery:       %d\n", meta.NumRecovery)
+	if meta.Ecc != [16]byte{} {
+		fmt.Printf("Header ecc:      %s\n", hex.EncodeToString(meta.Ecc[:]))
+	}
+	for _, r := range meta.Regions {
+		fmt.Printf("Region:          [%d, %d), #Recovery: %d\n", r.Start, r.End, r.NumRecovery)
+	}
+}
+
+func checkSize(what, name string, expected int64) bool {
+	fs, err := os.Stat(name)
+	if err != nil {
+		fmt.Printf("  %-6s FAIL  %v\n", what, err)
+		return false
+	}
+	if fs.Size() != expected {
+		fmt.Printf("  %-6s FAIL  %s has %d bytes, expected %d\n", what, name, fs.Size(), expected)
+		return false
+	}
+	fmt.Printf("  %-6s OK    %s\n", what, name)
+	return true
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+)
+
+const (
+	barWidth = 30
+	ttyInterval = 200 * time.Millisecond
+	lineInterval = 10 * time.Second // between progress lines when stderr is not a terminal
+)
+
+/**
+ * progressView renders progress reports on stderr: a bar redrawn in place
+ * on terminals, a line every lineInterval otherwise, and a summary at the end
+ */
+type progressView struct {
+	label string
+	tty bool
+	drawn bool // a bar is on screen
+	start time.Time
+	last time.Time
+}
+
+func newProgressView(label string) *progressView {
+	tty := false
+	if fi, err := os.Stderr.Stat(); err == nil {
+		tty = fi.Mode() & os.ModeCharDevice != 0
+	}
+	now := time.Now()
+	return &progressView{label: label, tty: tty, start: now, last: now}
+}
+
+// ReportAPI takes reports from the rsfileprotect package
+func (v *progressView) ReportAPI(p rsfileprotect.Progress) {
+	v.Report(progress.Stats(p))
+}
+
+func (v *progressView) Report(s progress.Stats) {
+	if verbosity > logging.Info {
+		return
+	}
+	now := time.Now()
+	if s.Finished {
+		if v.drawn {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+		v.summary(s, now.Sub(v.start))
+		return
+	}
+	interval := lineInterval
+	if v.tty {
+		interval = ttyInterval
+	}
+	if now.Sub(v.last) < interval {
+		return
+	}
+	v.last = now
+
+	line := v.line(s, now.Sub(v.start))
+	if v.tty {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+		v.drawn = true
+	} else {
+		log.Println(line)
+	}
+}
+
+func (v *progressView) line(s progress.Stats, elapsed time.Duration) string {
+	frac := 1.0
+	if s.Total > 0 {
+		frac = float64(s.Done) / float64(s.Total)
+	}
+	rate := float64(s.Done-s.Resumed) / elapsed.Seconds()
+	if s.Total < 0 {
+		// a stream, nothing to measure against
+		return fmt.Sprintf("%s %s %s/s", v.label, formatBytes(s.Done), formatBytes(int64(rate)))
+	}
+	eta := "--:--"
+	if rate > 0 {
+		eta = formatDuration(time.Duration(float64(s.Total-s.Done) / rate * float64(time.Second)))
+	}
+
+	var bar string
+	if v.tty {
+		n := int(frac * barWidth)
+		bar = "[" + strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n) + "] "
+	}
+	return fmt.Sprintf("%s %s%5.1f%% %s/%s %s/s ETA %s", v.label, bar, frac*100,
+		formatBytes(s.Done), formatBytes(s.Total), formatBytes(int64(rate)), eta)
+}
+
+func (v *progressView) summary(s progress.Stats, elapsed time.Duration) {
+	rate := float64(s.Done-s.Resumed) / elapsed.Seconds()
+	log.Printf("%s: %s in %s (%s/s), I/O %s, coding %s\n", v.label, formatBytes(s.Done-s.Resumed),
+		formatDuration(elapsed), formatBytes(int64(rate)), formatDuration(s.IO), formatDuration(s.Coding))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Millisecond).String()
+	}
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	sec := (d % time.Minute) / time.Second
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
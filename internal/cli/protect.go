@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"io"
+	"math"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/cmdparser"
+)
+
+type regionList []string
+
+func (r *regionList) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *regionList) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+type protectArgs struct {
+	prog string // recorded as the creator of the ecc file
+	data string
+	out string // where data read from stdin is stored
+	ecc string
+	blockSize int
+	level int
+	regions regionList
+	resume bool
+	showHelp bool
+	quiet, verbose bool
+}
+
+func protectFlags(name string, a *protectArgs) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.StringVar(&a.ecc, "ecc", "", "Filename of generated ecc file, <data>.ecc by default")
+	set.IntVar(&a.blockSize, "bs", 4096, "Size of chunks that files are splitted into during reed-solomon encoding")
+	set.IntVar(&a.level, "level", 1, "Number of ecc symbols per 10 data symbols, default 1")
+	set.StringVar(&a.data, "data", "", "Required, file to be encoded")
+	set.BoolVar(&a.showHelp, "h", false, "Prints this message")
+	set.BoolVar(&a.resume, "resume", true, "continue an interrupted encoding of the same file from its checkpoint, <ecc>.partial")
+	set.Var(&a.regions, "region", "Byte range START:END:LEVEL protected with its own number of ecc symbols, e.g. 0:1M:5 or -1M::5; may be repeated")
+	verbosityFlags(set, &a.quiet, &a.verbose)
+	return set
+}
+
+/**
+ * Runs the encoder with the legacy flag-style arguments, e.g.
+ * encoder -data file [-ecc file.ecc] [-level lvl]
+ */
+func Encoder(args []string) int {
+	a := protectArgs{prog: "encoder"}
+	set := protectFlags("encoder", &a)
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "Command usage:\n  encoder <-data filename> [-ecc filename] [-level lvl] [-region start:end:lvl ...]")
+		set.PrintDefaults()
+	}
+	if err := set.Parse(args); err != nil || set.NArg() != 0 || !a.sanitize() {
+		set.Usage()
+		return ExitError
+	}
+	return a.run()
+}
+
+// Protect runs "rsprotect protect [flags] FILE"
+func Protect(args []string) int {
+	a := protectArgs{prog: "rsprotect protect"}
+	set := protectFlags("protect", &a)
+	set.StringVar(&a.out, "out", "", "with FILE -, where to store the data read from stdin")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "Command usage:\n  rsprotect protect [-ecc filename] [-level lvl] [-region start:end:lvl ...] FILE\n  rsprotect protect -out FILE [-ecc filename] [-level lvl] -")
+		set.PrintDefaults()
+		fmt.Fprintln(set.Output(), "\nWith -, data of any length is read from stdin and stored in -out along with its sidecars, in one pass.")
+	}
+	if err := parseWithFile(set, args, &a.data); err != nil {
+		log.Println(err)
+		set.Usage()
+		return ExitError
+	}
+	if !a.sanitize() {
+		set.Usage()
+		return ExitError
+	}
+	return a.run()
+}
+
+func (a *protectArgs) sanitize() bool {
+	if a.showHelp {
+		return false
+	}
+	if a.data == "" {
+		return false
+	}
+	if a.data == "-" && a.out == "" {
+		log.Println("Data read from stdin needs -out")
+		return false
+	}
+	if a.data != "-" && a.out != "" {
+		log.Println("-out is only used with data read from stdin")
+		return false
+	}
+	if a.ecc == "" && a.data == "-" {
+		a.ecc = rsfileprotect.EccPathFor(a.out)
+	} else if a.ecc == "" {
+		a.ecc = rsfileprotect.EccPathFor(a.data)
+	}
+
+	if a.level < 1 || a.level > 10 {
+		log.Println("Only 1 to 10 symbols are allowed")
+		return false
+	}
+
+	if a.blockSize < 0 {
+		log.Println("Chunk size must be a positive integer")
+		return false
+	}
+
+	return true
+}
+
+func (a *protectArgs) run() int {
+	setVerbosity(a.quiet, a.verbose)
+	if a.data == "-" {
+		return a.runStream()
+	}
+	fs, err := os.Stat(a.data)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	opts := rsfileprotect.ProtectOptions{EccPath: a.ecc, BlockSize: a.blockSize, Level: a.level,
+		Creator: a.prog, Resume: a.resume, Progress: newProgressView("encode").ReportAPI, Log: logAPI}
+	for _, spec := range a.regions {
+		r, err := cmdparser.ParseRegion(spec, fs.Size())
+		if err != nil {
+			log.Println(err)
+			return ExitError
+		}
+		opts.Regions = append(opts.Regions, rsfileprotect.Region{Start: r.Start, End: r.End, Level: int(r.NumRecovery)})
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	err = rsfileprotect.Protect(ctx, a.data, opts)
+	switch {
+		case err == nil:
+			return ExitClean
+		case ctx.Err() != nil && exists(rsfileprotect.CheckpointPathFor(a.ecc)):
+			log.Printf("Incomplete %s kept, run again to resume\n", a.ecc)
+			return ExitInterrupted
+		case ctx.Err() != nil:
+			return ExitInterrupted
+	}
+	log.Println(err)
+	return ExitError
+}
+
+// runStream protects stdin into a.out, see rsfileprotect.Create
+func (a *protectArgs) runStream() int {
+	opts := rsfileprotect.ProtectOptions{EccPath: a.ecc, BlockSize: a.blockSize, Level: a.level,
+		Creator: a.prog, Progress: newProgressView("encode").ReportAPI, Log: logAPI}
+	for _, spec := range a.regions {
+		if strings.Contains(spec, "-") {
+			log.Printf("Region %s counts from the end of the data, which is not known for stdin\n", spec)
+			return ExitError
+		}
+		r, err := cmdparser.ParseRegion(spec, math.MaxInt64)
+		if err != nil {
+			log.Println(err)
+			return ExitError
+		}
+		opts.Regions = append(opts.Regions, rsfileprotect.Region{Start: r.Start, End: r.End, Level: int(r.NumRecovery)})
+	}
+
+	w, err := rsfileprotect.Create(a.out, opts)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	_, err = io.Copy(w, readerContext{ctx, os.Stdin})
+	if err == nil && ctx.Err() != nil {
+		// the producer may have stopped on the same signal, the data ends early
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = w.Close()
+	} else {
+		w.Close()
+	}
+	if err != nil {
+		// a stream cannot be resumed, nothing to keep
+		removeIncomplete(a.out, a.ecc, rsfileprotect.CRCPathFor(a.ecc))
+		if ctx.Err() != nil {
+			return ExitInterrupted
+		}
+		log.Println(err)
+		return ExitError
+	}
+	infof("Protected %d bytes from stdin in %s, sha256 %s\n", w.Size(), a.out, w.Digest())
+	return ExitClean
+}
+
+// readerContext stops reading once ctx is done
+type readerContext struct {
+	ctx context.Context
+	r io.Reader
+}
+
+func (rc readerContext) Read(p []byte) (int, error) {
+	if err := rc.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return rc.r.Read(p)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+type command struct {
+	name string
+	run func(args []string) int
+	summary string
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"protect", Protect, "Create ecc and crc sidecars for a file"},
+		{"verify", Verify, "Check a file against its sidecars and list damaged chunks"},
+		{"repair", Repair, "Scan and repair a file, or repair given damage positions"},
+		{"extract", Extract, "Write a verified byte range of a file, repairing it on the way"},
+		{"info", Info, "Show what an ecc file contains"},
+		{"serve", Serve, "Serve protected files over HTTP, healing damage on the fly"},
+		{"help", help, "Show help for a command"},
+	}
+}
+
+/**
+ * Runs "rsprotect <command> [args...]". Sidecars are found next to the data
+ * file using the encoder's naming, FILE.ecc and FILE.ecc.crc.
+ */
+func Main(args []string) int {
+	if len(args) < 1 {
+		printCommands(os.Stderr)
+		return ExitError
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	switch args[0] {
+		case "-h", "-help", "--help":
+			printCommands(os.Stderr)
+			return ExitClean
+	}
+	log.Printf("Unknown command %s\n", args[0])
+	printCommands(os.Stderr)
+	return ExitError
+}
+
+func help(args []string) int {
+	if len(args) == 1 {
+		for _, c := range commands {
+			if c.name == args[0] && c.name != "help" {
+				c.run([]string{"-h"})
+				return ExitClean
+			}
+		}
+	}
+	printCommands(os.Stderr)
+	return ExitClean
+}
+
+func printCommands(output io.Writer) {
+	fmt.Fprintf(output, "Command usage:\n  rsprotect <command> [Args...] FILE\n")
+	fmt.Fprintf(output, "\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(output, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(output, "\nRun \"rsprotect help <command>\" for the arguments of a command.\n")
+}
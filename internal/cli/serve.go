@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"github.com/AlexHalogen/RSFileProtect"
+)
+
+type serveArgs struct {
+	dir string
+	addr string
+	showHelp bool
+	quiet, verbose bool
+}
+
+/**
+ * Serve runs "rsprotect serve [-addr host:port] DIR", serving the protected
+ * files under DIR over HTTP and healing damaged sections on the fly, see
+ * rsfileprotect.FileServer. It runs until SIGINT or SIGTERM.
+ */
+func Serve(args []string) int {
+	var a serveArgs
+	set := flag.NewFlagSet("serve", flag.ContinueOnError)
+	set.StringVar(&a.addr, "addr", "localhost:8080", "address to listen on")
+	set.BoolVar(&a.showHelp, "h", false, "Prints this help message")
+	verbosityFlags(set, &a.quiet, &a.verbose)
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "Command usage:\n  rsprotect serve [-addr host:port] DIR")
+		set.PrintDefaults()
+		fmt.Fprintf(set.Output(), "\nOnly files with an ecc file next to them are served. Damaged sections are rebuilt as they are sent; the %s header lists those rebuilt before a response starts, the trailer of the same name all of them.\n", rsfileprotect.HealedHeader)
+	}
+	if err := parseWithFile(set, args, &a.dir); err != nil {
+		log.Println(err)
+		set.Usage()
+		return ExitError
+	}
+	if a.showHelp || a.dir == "" {
+		set.Usage()
+		return ExitError
+	}
+	setVerbosity(a.quiet, a.verbose)
+	if fi, err := os.Stat(a.dir); err != nil || !fi.IsDir() {
+		log.Printf("%s is not a directory\n", a.dir)
+		return ExitError
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	server := &http.Server{Addr: a.addr, Handler: rsfileprotect.FileServer(a.dir, rsfileprotect.ServerOptions{Log: logAPI})}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenAndServe()
+	}()
+	infof("Serving %s on http://%s\n", a.dir, a.addr)
+
+	select {
+		case err := <-done:
+			log.Println(err)
+			return ExitError
+		case <-ctx.Done():
+			// finish the responses in progress
+			server.Shutdown(context.Background())
+			return ExitClean
+	}
+}
@@ -1,17 +1,20 @@
 package cmdparser
 
 import (
+	"sort"
 	"strconv"
 	"strings"
-	"alexhalogen/rsfileprotect/internal/decoding"
-	"alexhalogen/rsfileprotect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 	"log"
 	"fmt"
 )
 
 func CSVToDamage(meta *types.Metadata, dataDmg, eccDmg []int) []decoding.DamageDesc {
+	dataDmg = sortedUnique(dataDmg)
+	eccDmg = sortedUnique(eccDmg)
 	nd := int(meta.NumData)
-	nr := int(meta.NumRecovery)
 	dmgs := make([]decoding.DamageDesc, 0, 16)
 
 	id := 0
@@ -20,8 +23,7 @@ func CSVToDamage(meta *types.Metadata, dataDmg, eccDmg []int) []decoding.DamageD
 	for id < len(dataDmg) && ie < len(eccDmg) {
 		c1 := dataDmg[id] / nd
 		r1 := dataDmg[id] % nd
-		c2 := eccDmg[ie] / nr
-		r2 := eccDmg[ie] % nr
+		c2, r2 := meta.EccChunkSection(eccDmg[ie])
 
 		if c1 <= c2 { // consume dataDmg
 			if c1 == cd.Section { // still working in the same section
@@ -59,8 +61,7 @@ func CSVToDamage(meta *types.Metadata, dataDmg, eccDmg []int) []decoding.DamageD
 	}
 
 	for ie < len(eccDmg) {
-		c := eccDmg[ie] / nr
-		r := eccDmg[ie] % nr
+		c, r := meta.EccChunkSection(eccDmg[ie])
 		if c == cd.Section { // still working in the same section
 			cd.EccDamage = append(cd.EccDamage, r)
 		} else {
@@ -77,11 +78,23 @@ func CSVToDamage(meta *types.Metadata, dataDmg, eccDmg []int) []decoding.DamageD
 }
 
 
+func sortedUnique(idx []int) []int {
+	sorted := append([]int{}, idx...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, v := range sorted {
+		if i == 0 || v != sorted[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+
 func DamageToCSV(dmgs []decoding.DamageDesc, meta *types.Metadata) (*string, *string){
 	var bd, be strings.Builder
 
 	nd := int(meta.NumData)
-	nr := int(meta.NumRecovery)
 
 	for _, d := range dmgs {
 		base := d.Section
@@ -93,7 +106,7 @@ func DamageToCSV(dmgs []decoding.DamageDesc, meta *types.Metadata) (*string, *st
 
 		if len(d.EccDamage) != 0 {
 			for _,v := range d.EccDamage {
-				fmt.Fprintf(&be, "%d,", base * nr + v)
+				fmt.Fprintf(&be, "%d,", meta.EccChunkStart(base) + v)
 			}
 		}
 	}
@@ -149,3 +162,197 @@ func CSVToIntArr(line string) []int {
 	}
 	return ret
 }
+
+
+/**
+ * Parses a region in the form START:END:LEVEL. Offsets are in bytes and may
+ * be written in hex (0x..) or with a K/M/G suffix; negative offsets count
+ * from the end of file and an empty END means end of file.
+ */
+func ParseRegion(spec string, fileSize int64) (types.Region, error) {
+	var r types.Region
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return r, fmt.Errorf("region %q is not in the form START:END:LEVEL", spec)
+	}
+
+	var err error
+	if r.Start, err = parseOffset(parts[0], fileSize, 0); err != nil {
+		return r, err
+	}
+	if r.End, err = parseOffset(parts[1], fileSize, fileSize); err != nil {
+		return r, err
+	}
+	if r.End <= r.Start {
+		return r, fmt.Errorf("region %q is empty", spec)
+	}
+
+	level, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil || level < 1 || level > 0xFFFF {
+		return r, fmt.Errorf("invalid level in region %q", spec)
+	}
+	r.NumRecovery = uint16(level)
+	return r, nil
+}
+
+func parseOffset(s string, fileSize int64, def int64) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return def, nil
+	}
+	v, err := ParseSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		v += fileSize
+	}
+	if v < 0 {
+		v = 0
+	}
+	if v > fileSize {
+		v = fileSize
+	}
+	return v, nil
+}
+
+// ParseSize parses a number of bytes, written in hex (0x..) or with a K/M/G suffix
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	unit := int64(1)
+	switch s[len(s)-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	return v * unit, nil
+}
+
+
+/**
+ * Parses a list of damaged chunks such as [3, 10-40, 0x1000-0x5fff]. Decimal
+ * numbers are chunk indices and hex numbers are byte offsets into the data or
+ * ecc file, ranges include both ends. The brackets are optional, the result is
+ * sorted and free of duplicates and every chunk is checked against meta
+ */
+func ParseChunkList(line string, meta *types.Metadata, ecc bool) ([]int, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		line = line[1:len(line)-1]
+	}
+	if strings.TrimSpace(line) == "" {
+		return []int{}, nil
+	}
+
+	numChunks := (meta.FileSize + int64(meta.BlockSize) - 1) / int64(meta.BlockSize)
+	kind := "data"
+	if ecc {
+		numChunks = int64(meta.EccChunkStart(meta.NumSections()))
+		kind = "ecc"
+	}
+
+	chunks := make([]int, 0, 16)
+	for _, item := range strings.Split(line, ",") {
+		item = strings.TrimSpace(item)
+		bounds := []string{item}
+		if i := strings.Index(item[minInt(1, len(item)):], "-"); i >= 0 {
+			bounds = []string{item[:i+1], item[i+2:]}
+		}
+
+		var first, last int64
+		var isByte [2]bool
+		for j, b := range bounds {
+			v, byteOffset, err := parseChunk(strings.TrimSpace(b), meta, ecc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s chunk %q: %v", kind, item, err)
+			}
+			isByte[j] = byteOffset
+			if j == 0 {
+				first = v
+			}
+			last = v
+		}
+		if len(bounds) == 2 && isByte[0] != isByte[1] {
+			return nil, fmt.Errorf("range %q mixes byte offsets and chunk indices", item)
+		}
+		if last < first {
+			return nil, fmt.Errorf("range %q ends before it starts", item)
+		}
+		if last >= numChunks {
+			return nil, fmt.Errorf("%s chunk %d in %q out of range, file has %d chunks", kind, last, item, numChunks)
+		}
+		for c := first; c <= last; c++ {
+			chunks = append(chunks, int(c))
+		}
+	}
+	return sortedUnique(chunks), nil
+}
+
+// FormatChunkList writes chunk indices in the form read by ParseChunkList, joining runs into ranges
+func FormatChunkList(idx []int) string {
+	idx = sortedUnique(idx)
+	var b strings.Builder
+	for i:=0; i<len(idx); {
+		j := i
+		for j+1 < len(idx) && idx[j+1] == idx[j]+1 {
+			j++
+		}
+		if b.Len() != 0 {
+			b.WriteString(",")
+		}
+		if j > i {
+			fmt.Fprintf(&b, "%d-%d", idx[i], idx[j])
+		} else {
+			fmt.Fprintf(&b, "%d", idx[i])
+		}
+		i = j+1
+	}
+	return b.String()
+}
+
+// parseChunk returns the chunk index written as either an index or a hex byte offset
+func parseChunk(s string, meta *types.Metadata, ecc bool) (int64, bool, error) {
+	if s == "" {
+		return 0, false, fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		off, err := strconv.ParseInt(s[2:], 16, 64)
+		if err != nil || off < 0 {
+			return 0, true, fmt.Errorf("bad byte offset %s", s)
+		}
+		if ecc {
+			if off < filehelper.HeaderSize {
+				return 0, true, fmt.Errorf("byte offset %s lies in the ecc file header", s)
+			}
+			off -= filehelper.HeaderSize
+		} else if off >= meta.FileSize {
+			return 0, true, fmt.Errorf("byte offset %s past end of file (%d bytes)", s, meta.FileSize)
+		}
+		return off / int64(meta.BlockSize), true, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, false, fmt.Errorf("bad chunk index %s", s)
+	}
+	return v, false, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
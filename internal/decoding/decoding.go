@@ -23,45 +23,198 @@ SOFTWARE.*/
 package decoding
 
 import (
-	"os"
-	"log"
-	"math"
+	"context"
+	"fmt"
+	"io"
 	"github.com/klauspost/reedsolomon"
     "hash/crc32"
-	"alexhalogen/rsfileprotect/internal/filehelper"
-	"alexhalogen/rsfileprotect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 )
 
+/**
+ * Options are the callbacks and settings of ScanWith, RepairWith and the
+ * others taking them; ScanFile, FastRepair and their variants run without
+ * any. Checkpoint is called with the number of scanned sections and the
+ * damages found in them every CheckpointInterval sections and when a scan is
+ * interrupted, a scan can be picked up from there with ScanWith. Salvage
+ * turns on retries and sector-sized reads for data files on failing media;
+ * sectors that stay unreadable are recorded in its Map, which a repair uses
+ * to rebuild sections from the readable parts of damaged chunks
+ */
+type Options struct {
+	Progress 			progress.Func // off if nil
+	Log 				logging.Func // receives damaged chunks at level Debug and summaries at Info, silent if nil
+	Checkpoint 			func(sections int, damages []DamageDesc) // off if nil
+	CheckpointInterval 	int // 1024 if zero
+	Salvage 			*filehelper.SalvageOptions // off if nil
+}
+
 type DamageDesc struct {
 	Section int
 	DataDamage []int
 	EccDamage []int
+	Details []ChunkDamage // why each chunk is damaged, only known to ScanFile
 }
 
-func ScanFile(meta *types.Metadata, dataFile *os.File, eccFile *os.File, crcFile *os.File) ([]DamageDesc, bool){	
-	err := false
-	damages := make([]DamageDesc, 0, 8)
-	
-	// seek file past meta area without using unsafe methods
+const (
+	DamageCRC = "crc"
+	DamageMissing = "missing"
+	DamageUnreadable = "unreadable"
+)
+
+type ChunkDamage struct {
+	Ecc 		bool
+	Index 		int // position within the section
+	Expected 	uint32 // crc recorded by the encoder
+	Actual 		uint32 // crc of the chunk as read, 0 if missing or unreadable
+	Reason 		string
+}
+
+/**
+ * ScanFile checks dataFile against the ecc and crc files, all read with ReadAt
+ * only, so that several scans can share them. dataFile is nil if missing.
+ * Errors wrap filehelper.ErrBadHeader or filehelper.ErrGeometryMismatch if
+ * the ecc and crc files are at fault
+ */
+func ScanFile(meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt) (*ScanResult, error){
+	return ScanFileContext(context.Background(), meta, dataFile, eccFile, crcFile)
+}
+
+// ScanFileContext stops once ctx is done, returning ctx.Err() along with the damages found so far
+func ScanFileContext(ctx context.Context, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt) (*ScanResult, error){
+	return ScanFileFrom(ctx, meta, dataFile, eccFile, crcFile, 0)
+}
+
+/**
+ * ScanFileFrom skips the first sections, e.g. those checked before a scan was
+ * interrupted, and only returns damages found after them
+ */
+func ScanFileFrom(ctx context.Context, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt, first int) (*ScanResult, error){
+	return ScanWith(ctx, &Options{}, meta, dataFile, eccFile, crcFile, first)
+}
+
+// readMeta reads the metadata of eccFile and checks it against meta, if given
+func readMeta(meta *types.Metadata, eccFile io.ReaderAt) (*types.Metadata, error) {
 	var fmeta types.Metadata;
-	metaErr := filehelper.ReadMeta(eccFile, &fmeta)
-	if metaErr != nil {
-		log.Println(metaErr)
-		log.Println("Failed to read metadata from ecc file!")
-		return damages, true
+	if err := filehelper.ReadMeta(eccFile, &fmeta); err != nil {
+		return nil, err
 	}
 	// trust metadata read from file if not specified in parameters
 	if meta == nil {
-		meta = &fmeta
+		return &fmeta, nil
+	}
+	if !meta.SameGeometry(&fmeta) {
+		return nil, fmt.Errorf("%w: given metadata differs from the ecc file", filehelper.ErrGeometryMismatch)
+	}
+	return meta, nil
+}
+
+func ScanWith(ctx context.Context, opts *Options, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt, first int) (*ScanResult, error){
+	res := &ScanResult{Damages: make([]DamageDesc, 0, 8), Sections: first}
+
+	meta, err := readMeta(meta, eccFile)
+	if err != nil {
+		return res, err
+	}
+	interval := opts.CheckpointInterval
+	if interval <= 0 {
+		interval = 1024
+	}
+	sections := meta.NumSections()
+	if diff, err := SizeDiff(meta, dataFile); err == nil {
+		res.SizeDiff = diff
+	}
+
+	err = walk(ctx, opts, meta, dataFile, eccFile, crcFile, first, func(ev *SectionEvent) error {
+		if ev.Status == SectionFailed {
+			return ev.Err
+		}
+		if ev.Damage != nil {
+			res.Damages = append(res.Damages, *ev.Damage)
+		}
+		res.Sections = ev.Section+1
+		if opts.Checkpoint != nil && res.Sections % interval == 0 && res.Sections < sections {
+			opts.Checkpoint(res.Sections, res.Damages)
+		}
+		return nil
+	})
+	if err != nil {
+		if err == ctx.Err() && opts.Checkpoint != nil {
+			opts.Checkpoint(res.Sections, res.Damages)
+		}
+		return res, err
 	}
 
+	logging.New(opts.Log).Info("Scan finished", logging.F("sections", sections), logging.F("damaged", len(res.Damages)))
+	return res, nil
+}
+
+/**
+ * ScanStream is ScanWith for data that can only be read once from start to
+ * end, e.g. from a pipe or a tape. The data is read strictly in order, and
+ * whatever follows the protected bytes is read to the end to tell SizeDiff.
+ * Salvage in opts is not used, a failed read of a stream cannot be retried.
+ * Errors reading data are returned rather than reported as damage.
+ */
+func ScanStream(ctx context.Context, opts *Options, meta *types.Metadata, data io.Reader, eccFile io.ReaderAt, crcFile io.ReaderAt) (*ScanResult, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	so := *opts
+	so.Salvage = nil
+	meta, err := readMeta(meta, eccFile)
+	if err != nil {
+		return &ScanResult{}, err
+	}
+	stream := filehelper.NewSequentialReaderAt(data)
+	res, err := ScanWith(ctx, &so, meta, stream, eccFile, crcFile, 0)
+	if err == nil {
+		err = stream.Err()
+	}
+	if err != nil {
+		return res, err
+	}
+
+	size, err := stream.Drain()
+	if err != nil {
+		return res, err
+	}
+	res.SizeDiff = size - meta.FileSize
+	logger := logging.New(opts.Log)
+	if res.SizeDiff < 0 {
+		logger.Warn("Data stream is truncated", logging.F("size", size), logging.F("expected", meta.FileSize))
+	} else if res.SizeDiff > 0 {
+		logger.Warn("Data stream has extra bytes", logging.F("extra", res.SizeDiff), logging.Offset(meta.FileSize))
+	}
+	return res, nil
+}
+
+/**
+ * Walk checks one section after the other like ScanFile, handing each to fn
+ * as soon as it is done instead of collecting the damages. If fn returns
+ * SkipRest the walk ends and Walk returns nil, other errors from fn end it
+ * and are returned. A section that cannot be checked is passed to fn with
+ * SectionFailed before its error is returned.
+ */
+func Walk(ctx context.Context, opts *Options, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt, first int, fn func(*SectionEvent) error) error {
+	meta, err := readMeta(meta, eccFile)
+	if err != nil {
+		return err
+	}
+	return walk(ctx, opts, meta, dataFile, eccFile, crcFile, first, fn)
+}
+
+func walk(ctx context.Context, opts *Options, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt, first int, fn func(*SectionEvent) error) error {
 	numData := (int)(meta.NumData)
-	numRecovery := (int)(meta.NumRecovery)
+	maxRecovery := meta.MaxRecovery()
 	bufferSize := (int)(meta.BlockSize)
 
 	fileBufferPages := make([][]byte, numData);
-	eccBuffer := make([][]byte, numRecovery)
-	crcBuffer := make([]uint32, numData+numRecovery)
+	eccBufferPages := make([][]byte, maxRecovery)
+	crcBufferPages := make([]uint32, numData+maxRecovery)
 	fileBuffer := make([][]byte, numData)
 	zero_page := make([]byte, bufferSize)
 	
@@ -69,27 +222,66 @@ func ScanFile(meta *types.Metadata, dataFile *os.File, eccFile *os.File, crcFile
 	for i, _ := range fileBuffer {
 		fileBufferPages[i] = make([]byte, bufferSize)
 	}
-	for i, _ := range eccBuffer {
-		eccBuffer[i] = make([]byte, bufferSize)
+	for i, _ := range eccBufferPages {
+		eccBufferPages[i] = make([]byte, bufferSize)
+	}
+	eccChunks := int64(meta.EccChunkStart(first))
+	eccReader := filehelper.NewChunkedReader(eccFile, bufferSize, filehelper.HeaderSize+eccChunks*int64(bufferSize))
+	fileReader := filehelper.NewChunkedReader(dataFile, bufferSize, int64(first)*meta.SectionSize())
+	if opts.Salvage != nil {
+		fileReader.SetSalvage(opts.Salvage)
 	}
-	eccReader := filehelper.NewChunkedReader(eccFile, bufferSize, 0)
-	fileReader := filehelper.NewChunkedReader(dataFile, bufferSize, 0)
-	crcReader := filehelper.NewCRCReader(crcFile, 0)
-	batchCount := 0
+	crcReader := filehelper.NewCRCReader(crcFile, (int64(first)*int64(numData)+eccChunks)*4, 0)
+	sections := meta.NumSections()
+
+	dataEnded := false
+	tracker := progress.NewTracker(opts.Progress, meta.FileSize)
+	tracker.Resume(int64(first) * meta.SectionSize())
+	defer tracker.Finish()
+	logger := logging.New(opts.Log)
+
+	if diff, sizeErr := SizeDiff(meta, dataFile); sizeErr != nil {
+		logger.Info("Size of the data file not checked", logging.Err(sizeErr))
+	} else if diff < 0 {
+		logger.Warn("Data file is truncated", logging.F("size", meta.FileSize+diff), logging.F("expected", meta.FileSize))
+	} else if diff > 0 {
+		logger.Warn("Data file has extra bytes", logging.F("extra", diff), logging.Offset(meta.FileSize))
+	}
+
+	// the section is passed to fn for the record, the walk ends with err either way
+	failed := func(section int, err error) error {
+		fn(&SectionEvent{Section: section, Status: SectionFailed, Err: err})
+		return err
+	}
+
+	// stop at the last section, the ecc file may carry a trailer after it
+	for batchCount:=first; batchCount<sections; batchCount++ {
+		if ctx.Err() != nil {
+			logger.Warn("Scan interrupted", logging.Section(batchCount), logging.Err(ctx.Err()))
+			return ctx.Err()
+		}
+		numRecovery := meta.RecoveryAt(batchCount)
+		expected := meta.DataChunksAt(batchCount)
+		eccBuffer := eccBufferPages[:numRecovery]
+		crcBuffer := crcBufferPages[:numData+numRecovery]
 
-	for {
-		fileBuffer = fileBufferPages
-		fRead, feof := fileReader.ReadNext(fileBuffer)
+		copy(fileBuffer, fileBufferPages)
+		fRead, feof := 0, true
+		if dataFile != nil {
+			fRead, feof = fileReader.ReadNext(fileBuffer)
+		}
 		eRead, eeof := eccReader.ReadNext(eccBuffer) // eRead == len(eccBuffer), else there should be some problem..
+		tracker.IODone()
 
-		if feof && eeof {
-			break
+		if eeof {
+			return failed(batchCount, fmt.Errorf("%w: ecc file ended before section %d", filehelper.ErrGeometryMismatch, batchCount))
 		}
 
-		if feof || eeof {
-			log.Println("File read error: ecc/data ended earlier than the other")
-			err = true
-			// return // ?
+		if (feof || fRead < expected) && !dataEnded {
+			// missing chunks are erasures, repairable as long as there is enough ecc
+			logger.Info("Data file ended early", logging.Section(batchCount), logging.Chunk(batchCount*numData+fRead),
+				logging.F("expected", (meta.FileSize+int64(bufferSize)-1)/int64(bufferSize)))
+			dataEnded = true
 		}
 
 		if fRead < numData {
@@ -98,167 +290,345 @@ func ScanFile(meta *types.Metadata, dataFile *os.File, eccFile *os.File, crcFile
 				fileBuffer[i] = zero_page
 			}
 		}
+		clearPadding(meta, batchCount, fileBuffer)
 
 		if eRead < numRecovery {
-			// error
-			log.Printf("ECC Read Error at chunk %d\n", batchCount*numRecovery+eRead)
-			err = true
-			return damages, err
+			return failed(batchCount, fmt.Errorf("%w: ecc file ended at chunk %d", filehelper.ErrGeometryMismatch, meta.EccChunkStart(batchCount)+eRead))
 		}
 
-		crcs, err := crcReader.ReadNext(crcBuffer)
-		if err != nil {
-			log.Println(err)
-			return damages, true
-		}
-		if crcs != len(crcBuffer) {
-			log.Println("Less crc read")
+		badData, dataErr := fileReader.Unreadable()
+		badEcc, eccErr := eccReader.Unreadable()
+
+		if _, err := crcReader.ReadNext(crcBuffer); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return failed(batchCount, fmt.Errorf("%w: crc file ended before section %d", filehelper.ErrGeometryMismatch, batchCount))
+		} else if err != nil {
+			return failed(batchCount, err)
 		}
+		tracker.IODone()
 
-		dDamages := make([]int, 0,2)
-		eDamages := make([]int, 0,2)
+		dDamages, details := checkChunks(logger, meta, batchCount, false, fileBuffer[:expected], crcBuffer[:numData], fRead, badData, dataErr)
+		eDamages, eDetails := checkChunks(logger, meta, batchCount, true, eccBuffer, crcBuffer[numData:], len(eccBuffer), badEcc, eccErr)
+		details = append(details, eDetails...)
 
-		for i:=0; i<fRead; i++ {
-			buf := fileBuffer[i]
-			crc := crc32.ChecksumIEEE(buf)
-			if crcBuffer[i] != crc {
-				idx := batchCount*numData+i
-				log.Printf("Data Block %d damaged, has crc %x, expected %x\n", idx, crc, crcBuffer[i])
-				dDamages = append(dDamages, i)
-			}
+		ev := SectionEvent{Section: batchCount, Status: SectionOK, Data: fileBuffer, Ecc: eccBuffer, read: fRead}
+		if len(dDamages) > 0 || len(eDamages) > 0 {
+			ev.Status = SectionDamaged
+			ev.Damage = &DamageDesc{batchCount, dDamages, eDamages, details}
 		}
+		tracker.CodingDone()
+		if err := fn(&ev); err == SkipRest {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		tracker.IODone() // fn mostly waits on its own output, e.g. the writes of a repair
+		tracker.Advance(int64(batchCount+1) * meta.SectionSize())
+	}
+	return nil
+}
 
+/**
+ * Compares the data or ecc chunks of a section with their crcs, returning the
+ * positions of the damaged ones and why. Chunks from read on are missing
+ */
+func checkChunks(logger logging.Logger, meta *types.Metadata, section int, ecc bool, chunks [][]byte, crcs []uint32, read int, unreadable []int, readErr error) ([]int, []ChunkDamage) {
+	damages := make([]int, 0, 2)
+	var details []ChunkDamage
+	blockSize := int64(meta.BlockSize)
+	kind, first, base := "Data", section*int(meta.NumData), int64(0)
+	if ecc {
+		kind, first, base = "Ecc", meta.EccChunkStart(section), filehelper.HeaderSize
+	}
 
-		for i, buf := range eccBuffer {
-			crc := crc32.ChecksumIEEE(buf)
-			if crcBuffer[i+numData] != crc {
-				idx := batchCount*numRecovery+i
-				log.Printf("ECC  Block %d damaged, has crc %x, expected %x\n", idx, crc, crcBuffer[i+numData])
-				eDamages = append(eDamages, i)
-			}
+	for i, buf := range chunks {
+		idx := first+i
+		if i >= read {
+			damages = append(damages, i)
+			details = append(details, ChunkDamage{Ecc: ecc, Index: i, Expected: crcs[i], Reason: DamageMissing})
+			continue
 		}
-
-		if len(dDamages) > 0 || len(eDamages) > 0 {
-			damages = append(damages, DamageDesc{batchCount, dDamages, eDamages})
+		if contains(unreadable, i) {
+			logger.Debug(kind+" chunk unreadable", logging.Section(section), logging.Chunk(idx),
+				logging.Offset(base+int64(idx)*blockSize), logging.Err(readErr))
+			damages = append(damages, i)
+			details = append(details, ChunkDamage{Ecc: ecc, Index: i, Expected: crcs[i], Reason: DamageUnreadable})
+			continue
+		}
+		crc := crc32.ChecksumIEEE(buf)
+		if crcs[i] != crc {
+			logger.Debug(kind+" chunk damaged", logging.Section(section), logging.Chunk(idx),
+				logging.Offset(base+int64(idx)*blockSize), logging.F("crc", logging.CRC(crc)), logging.F("expected", logging.CRC(crcs[i])))
+			damages = append(damages, i)
+			details = append(details, ChunkDamage{Ecc: ecc, Index: i, Expected: crcs[i], Actual: crc, Reason: DamageCRC})
 		}
-		batchCount += 1
 	}
-
-	return damages, err
+	return damages, details
 }
 
-
 /**
- * Fast repair by setting damaged chunks to nil;
- * return location of repaired sections and whether all damages have been repaired
+ * Fast repair by setting damaged chunks to nil, writing the whole data file
+ * to outFile. Sections beyond repair are written as zeros and listed in the
+ * result, with a SectionError wrapping ErrUnrecoverableSection for the first
+ * of them. Other errors stop the repair
  */
-func FastRepair(meta *types.Metadata, outFile *os.File, dataFile *os.File, eccFile *os.File, damages []DamageDesc) ([]int, bool) {
-	success := true
-	repaired := make([]int, 0, len(damages))
+func FastRepair(meta *types.Metadata, outFile io.Writer, dataFile io.ReaderAt, eccFile io.ReaderAt, damages []DamageDesc) (*RepairResult, error) {
+	return FastRepairContext(context.Background(), meta, outFile, dataFile, eccFile, damages)
+}
 
-	// seek file past meta area without using unsafe methods
-	var fmeta types.Metadata;
-	metaErr := filehelper.ReadMeta(eccFile, &fmeta)
-	if metaErr != nil {
-		log.Println(metaErr)
-		log.Println("Failed to read metadata from ecc file!")
-		return repaired, success
-	}
-	// trust metadata read from file if not specified in parameters
-	if meta == nil {
-		meta = &fmeta
+// FastRepairContext stops between two sections once ctx is done, leaving outFile incomplete
+func FastRepairContext(ctx context.Context, meta *types.Metadata, outFile io.Writer, dataFile io.ReaderAt, eccFile io.ReaderAt, damages []DamageDesc) (*RepairResult, error) {
+	return RepairWith(ctx, &Options{}, meta, outFile, dataFile, eccFile, damages)
+}
+
+func RepairWith(ctx context.Context, opts *Options, meta *types.Metadata, outFile io.Writer, dataFile io.ReaderAt, eccFile io.ReaderAt, damages []DamageDesc) (*RepairResult, error) {
+	meta, err := readMeta(meta, eccFile)
+	if err != nil {
+		return &RepairResult{}, err
 	}
 
+	r := NewRepairer(opts, meta, outFile, 0)
 	numData := int(meta.NumData)
-	fileSize := int(meta.FileSize)
-	numRecovery := int(meta.NumRecovery)
-	eccReader := filehelper.NewChunkedReader(eccFile, int(meta.BlockSize), 0)
+	eccReader := filehelper.NewChunkedReader(eccFile, int(meta.BlockSize), filehelper.HeaderSize)
 	fileReader := filehelper.NewChunkedReader(dataFile, int(meta.BlockSize), 0)
-	blockSize := int(meta.BlockSize)
-	zero_page := make([]byte, blockSize)
-
-	fileBufferPages := make([][]byte, numData)
-	for i := range fileBufferPages {
-		fileBufferPages[i] = make([]byte, blockSize)
-	}
-	eccBufferPages := make([][]byte, numRecovery)
-	for i := range eccBufferPages {
-		eccBufferPages[i] = make([]byte, blockSize)
+	if opts.Salvage != nil {
+		fileReader.SetSalvage(opts.Salvage)
 	}
-
-	enc, _ := reedsolomon.New(numData, numRecovery)
+	blockSize := int(meta.BlockSize)
 
 	cur := 0
-	eof := false
-	sections := int(math.Ceil(float64(fileSize)/float64(numData*blockSize)))
+	sections := meta.NumSections()
 	fileBuffer := make([][]byte, numData)
-	eccBuffer := make([][]byte, numRecovery)
 	for i:=0; i<sections; i++ {
-		copy(fileBuffer, fileBufferPages)
-		copy(eccBuffer, eccBufferPages)
+		if ctx.Err() != nil {
+			r.logger.Warn("Repair interrupted", logging.Section(i), logging.Err(ctx.Err()))
+			return &r.res, ctx.Err()
+		}
+		numRecovery := meta.RecoveryAt(i)
+		expected := meta.DataChunksAt(i)
+		eccBuffer := make([][]byte, numRecovery)
+		copy(fileBuffer, r.dataPages)
+		copy(eccBuffer, r.eccPages)
 		
-		var chunksRead int
-		chunksRead, eof = fileReader.ReadNext(fileBuffer)
-		if eof {
-			break
+		// a missing or truncated data file leaves erasures in place of the chunks
+		chunksRead := 0
+		var badData []int
+		if dataFile != nil {
+			chunksRead, _ = fileReader.ReadNext(fileBuffer)
+			badData, _ = fileReader.Unreadable()
+		}
+		for j:=expected; j<numData; j++ {
+			fileBuffer[j] = r.zero // padding used during encoding
 		}
+		clearPadding(meta, i, fileBuffer)
 
+		var dmg DamageDesc
 		if cur < len(damages) && i == damages[cur].Section { // damage with in this range
-			dmg := damages[cur]
+			dmg = damages[cur]
 			cur++
-			_, eof := eccReader.ReadNext(eccBuffer)
-			if eof {
-				log.Println("EOF during read to ecc file\n")
-				success = false
-				break
+		}
+		dataDamage := union(dmg.DataDamage, badData)
+		if chunksRead < expected {
+			dataDamage = union(dataDamage, span(chunksRead, expected))
+		}
+
+		if len(dataDamage) != 0 {
+			eRead, _ := eccReader.ReadNext(eccBuffer)
+			if eRead < numRecovery {
+				return &r.res, fmt.Errorf("%w: ecc file ended at chunk %d", filehelper.ErrGeometryMismatch, meta.EccChunkStart(i)+eRead)
 			}
+			badEcc, _ := eccReader.Unreadable()
+			r.record(i, r.fix(i, fileBuffer, eccBuffer, dataDamage, union(dmg.EccDamage, badEcc), chunksRead))
+		} else { // no damage occured within the range, skip a section of ecc file		
+			eccReader.SkipNext(numRecovery, blockSize)
+		}
+
+		if err := r.write(i, fileBuffer); err != nil {
+			return &r.res, err
+		}
+	}
+
+	return r.Finish()
+}
+
+/**
+ * Repairs a section one sector at a time. A chunk with a few unreadable sectors
+ * is an erasure only where those sectors are, so a section with more damaged
+ * chunks than ecc chunks can still be rebuilt if the lost sectors don't line up
+ */
+func repairSectors(salvage *filehelper.SalvageOptions, enc reedsolomon.Encoder, fileBuffer, eccBuffer [][]byte, dataDamage, eccDamage []int, base int64, chunksRead int) bool {
+	bad := salvage.Map
+	numData := len(fileBuffer)
+	numRecovery := len(eccBuffer)
+	blockSize := len(fileBuffer[0])
+	ss := salvage.SectorSize
+	if ss <= 0 || ss > blockSize {
+		ss = blockSize
+	}
+	chunkOffset := func(k int, o int) int64 {
+		return base + int64(k*blockSize + o)
+	}
+
+	// damaged chunks without unreadable sectors are lost as a whole
+	whole := make(map[int]bool)
+	partial := make(map[int]bool)
+	for _, d := range dataDamage {
+		if d < chunksRead && bad.Overlaps(chunkOffset(d, 0), chunkOffset(d+1, 0)) {
+			partial[d] = true
+		} else {
+			whole[d] = true
+		}
+	}
+	for _, d := range eccDamage {
+		whole[numData+d] = true
+	}
+	if len(partial) == 0 {
+		return false
+	}
 
-			totalDmg := len(dmg.DataDamage) + len(dmg.EccDamage)
-			if len(dmg.DataDamage) == 0 {
-				// only ecc damage, no need to repair
-			} else if totalDmg > numRecovery {
-				log.Printf("Failed to repair block %d-%d due to too many damages\n", i*numData, (i+1)*numData)
-				success = false
-				for i := range fileBuffer {
-					fileBuffer[i] = zero_page
-				}
+	shards := make([][]byte, 0, numData+numRecovery)
+	shards = append(append(shards, fileBuffer...), eccBuffer...)
+	sub := make([][]byte, len(shards))
+	for o:=0; o<blockSize; o+=ss {
+		end := o+ss
+		if end > blockSize {
+			end = blockSize
+		}
+		erased := 0
+		for k := range shards {
+			if whole[k] || (partial[k] && bad.Overlaps(chunkOffset(k, o), chunkOffset(k, end))) {
+				sub[k] = nil
+				erased++
 			} else {
-				// necessary and able to repair
-				for _,d := range dmg.DataDamage {
-					fileBuffer[d] = nil
-				}
-				for _,d := range dmg.EccDamage {
-					eccBuffer[d] = nil
-				}
-
-				repairBuffer := make([][]byte, numData+numRecovery)
-				copy(repairBuffer, fileBuffer)
-				copy(repairBuffer[numData:], eccBuffer)
-				enc.Reconstruct(repairBuffer)
-				ok, err := enc.Verify(repairBuffer)
-				if !ok || err != nil {
-					log.Printf("Reconstruction failed unexpectedly at block %d-%d\n", i, i+numData)
-					success = false
-				}
-				copy(fileBuffer, repairBuffer[:numData]) // copy back repaired chunks for writing
-				repaired = append(repaired, dmg.Section)
+				sub[k] = shards[k][o:end]
 			}
+		}
+		if erased == 0 {
+			continue
+		}
+		if erased > numRecovery || enc.Reconstruct(sub) != nil {
+			return false
+		}
+		for k := range shards {
+			copy(shards[k][o:end], sub[k])
+		}
+	}
+	ok, err := enc.Verify(shards)
+	return ok && err == nil
+}
 
-		} else { // no damage occured within the range, skip a section of ecc file		
-			eccReader.SkipNext(numRecovery, blockSize)
+// union merges two sorted lists of chunk indices
+func union(a, b []int) []int {
+	merged := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var v int
+		if j == len(b) || (i < len(a) && a[i] <= b[j]) {
+			v = a[i]
+			i++
+		} else {
+			v = b[j]
+			j++
+		}
+		if l := len(merged); l == 0 || merged[l-1] != v {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// span returns chunk indices [from, to)
+func span(from, to int) []int {
+	s := make([]int, 0, to-from)
+	for i:=from; i<to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+func contains(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
 		}
+	}
+	return false
+}
+
+/**
+ * Compares the size of the data file with the one recorded in metadata;
+ * negative for missing bytes, positive for extra bytes after the end of file
+ */
+func SizeDiff(meta *types.Metadata, dataFile io.ReaderAt) (int64, error) {
+	if dataFile == nil {
+		return -meta.FileSize, nil
+	}
+	size := filehelper.Size(dataFile)
+	if size < 0 {
+		return 0, filehelper.ErrUnknownSize
+	}
+	return size - meta.FileSize, nil
+}
+
+// clearPadding zeroes bytes past the end of file in the last data chunk, as
+// the encoder saw them. Otherwise an extended data file looks like damage
+func clearPadding(meta *types.Metadata, section int, buffer [][]byte) {
+	bs := int(meta.BlockSize)
+	tail := int(meta.FileSize % int64(bs))
+	if tail == 0 || section != meta.NumSections()-1 {
+		return
+	}
+	last := meta.DataChunksAt(section) - 1
+	if buffer[last] != nil {
+		filehelper.Memset(buffer[last], 0, bs-tail, tail)
+	}
+}
 
-		for j:=0; j<chunksRead; j++ {
-			_, err := outFile.Write(fileBuffer[j])
-			if err != nil {
-				log.Println(err)
+// DamageFromMap marks every data chunk overlapping an unreadable range as damaged
+func DamageFromMap(meta *types.Metadata, bad *filehelper.BadMap) []DamageDesc {
+	bs := int64(meta.BlockSize)
+	nd := int64(meta.NumData)
+	damages := make([]DamageDesc, 0, len(bad.Ranges))
+
+	for _, r := range bad.Ranges {
+		end := r.End
+		if end > meta.FileSize {
+			end = meta.FileSize
+		}
+		if r.Start >= end {
+			continue
+		}
+		for c := r.Start/bs; c <= (end-1)/bs; c++ {
+			section, idx := int(c/nd), int(c%nd)
+			l := len(damages)
+			if l == 0 || damages[l-1].Section != section {
+				damages = append(damages, DamageDesc{Section: section, DataDamage: []int{idx}})
+			} else if d := &damages[l-1]; d.DataDamage[len(d.DataDamage)-1] != idx {
+				d.DataDamage = append(d.DataDamage, idx)
 			}
 		}
 	}
+	return damages
+}
 
-	size := meta.FileSize
-	if size % int64(blockSize) != 0 { // truncate the end of file	
-		outFile.Truncate(size)
+// MergeDamages combines two lists of damages sorted by section
+func MergeDamages(a, b []DamageDesc) []DamageDesc {
+	merged := make([]DamageDesc, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+			case j == len(b) || (i < len(a) && a[i].Section < b[j].Section):
+				merged = append(merged, a[i])
+				i++
+			case i == len(a) || b[j].Section < a[i].Section:
+				merged = append(merged, b[j])
+				j++
+			default:
+				merged = append(merged, DamageDesc{a[i].Section,
+					union(a[i].DataDamage, b[j].DataDamage), union(a[i].EccDamage, b[j].EccDamage),
+					append(append([]ChunkDamage{}, a[i].Details...), b[j].Details...)})
+				i++
+				j++
+		}
 	}
-	return repaired, success
-}
\ No newline at end of file
+	return merged
+}
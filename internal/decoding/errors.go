@@ -0,0 +1,48 @@
+package decoding
+
+import (
+	"errors"
+	"fmt"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+var (
+	// ErrUnrecoverableSection is wrapped by a SectionError for every section FastRepair could not rebuild
+	ErrUnrecoverableSection = errors.New("section damaged beyond repair")
+	// ErrReconstruction is wrapped by a SectionError if a rebuilt section does not verify
+	ErrReconstruction = errors.New("reconstructed section failed verification")
+	// ErrStalePadding is wrapped by a SectionError for a last section whose padding the ecc file does not record
+	ErrStalePadding = errors.New("section cannot be rebuilt, the ecc file does not record its padding")
+)
+
+// SectionError is an error about one section
+type SectionError struct {
+	Section 	int
+	Err 		error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("section %d: %v", e.Section, e.Err)
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// ScanResult is what ScanFile found
+type ScanResult struct {
+	Damages 	[]DamageDesc // sorted by section
+	Sections 	int // sections checked, including those skipped by ScanFileFrom
+	SizeDiff 	int64 // see SizeDiff, 0 if the size of the data file is unknown
+}
+
+// RepairResult is what FastRepair did
+type RepairResult struct {
+	Repaired 	[]int // sections rebuilt from ecc chunks
+	Failed 		[]int // sections damaged beyond repair, written as zeros
+}
+
+// Repairable reports whether a section with these damages can be rebuilt
+func (d *DamageDesc) Repairable(meta *types.Metadata) bool {
+	return len(d.DataDamage) == 0 || len(d.DataDamage)+len(d.EccDamage) <= meta.RecoveryAt(d.Section)
+}
@@ -0,0 +1,120 @@
+package decoding
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+/**
+ * Healer reads single sections of a protected file, checking their data
+ * chunks with the crc file and rebuilding damaged ones from the ecc file in
+ * memory. Ecc chunks are only read for damaged sections, and no file is ever
+ * written. Sections may be read from several goroutines at once.
+ */
+type Healer struct {
+	meta 		*types.Metadata
+	data 		io.ReaderAt // nil if missing
+	ecc 		io.ReaderAt
+	crc 		io.ReaderAt
+	salvage 	*filehelper.SalvageOptions
+	logger 		logging.Logger
+	mu 			sync.Mutex // guards repairer, it caches its coders
+	repairer 	*Repairer
+}
+
+// NewHealer returns a Healer for the files, reading meta from the ecc file if nil
+func NewHealer(opts *Options, meta *types.Metadata, dataFile io.ReaderAt, eccFile io.ReaderAt, crcFile io.ReaderAt) (*Healer, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	meta, err := readMeta(meta, eccFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Healer{meta: meta, data: dataFile, ecc: eccFile, crc: crcFile, salvage: opts.Salvage,
+		logger: logging.New(opts.Log), repairer: NewRepairer(opts, meta, nil, 0)}, nil
+}
+
+func (h *Healer) Meta() *types.Metadata {
+	return h.meta
+}
+
+/**
+ * Section returns the data of section i, without the padding after the end of
+ * file. damage is set if chunks had to be rebuilt. A section beyond repair
+ * returns damage along with a SectionError
+ */
+func (h *Healer) Section(i int) (data []byte, damage *DamageDesc, err error) {
+	meta := h.meta
+	if i < 0 || i >= meta.NumSections() {
+		return nil, nil, fmt.Errorf("section %d out of range, the file has %d", i, meta.NumSections())
+	}
+	numData := int(meta.NumData)
+	numRecovery := meta.RecoveryAt(i)
+	expected := meta.DataChunksAt(i)
+	bs := int(meta.BlockSize)
+
+	buffer := make([]byte, numData*bs) // chunks past the end of file stay zero, as the encoder saw them
+	fileBuffer := make([][]byte, numData)
+	for j := range fileBuffer {
+		fileBuffer[j] = buffer[j*bs : (j+1)*bs]
+	}
+	fRead := 0
+	var badData []int
+	var dataErr error
+	if h.data != nil {
+		fileReader := filehelper.NewChunkedReader(h.data, bs, int64(i)*meta.SectionSize())
+		if h.salvage != nil {
+			fileReader.SetSalvage(h.salvage)
+		}
+		fRead, _ = fileReader.ReadNext(fileBuffer[:expected])
+		badData, dataErr = fileReader.Unreadable()
+	}
+	clearPadding(meta, i, fileBuffer)
+
+	eccStart := meta.EccChunkStart(i)
+	crcs := make([]uint32, numData+numRecovery)
+	crcReader := filehelper.NewCRCReader(h.crc, (int64(i)*int64(numData)+int64(eccStart))*4, len(crcs)*4)
+	if _, err := crcReader.ReadNext(crcs); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, nil, fmt.Errorf("%w: crc file ended before section %d", filehelper.ErrGeometryMismatch, i)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	size := meta.FileSize - int64(i)*meta.SectionSize()
+	if size > meta.SectionSize() {
+		size = meta.SectionSize()
+	}
+	dDamages, details := checkChunks(h.logger, meta, i, false, fileBuffer[:expected], crcs[:numData], fRead, badData, dataErr)
+	if len(dDamages) == 0 {
+		return buffer[:size], nil, nil
+	}
+
+	eccBuffer := make([][]byte, numRecovery)
+	for j := range eccBuffer {
+		eccBuffer[j] = make([]byte, bs)
+	}
+	eccReader := filehelper.NewChunkedReader(h.ecc, bs, filehelper.HeaderSize+int64(eccStart)*int64(bs))
+	if eRead, _ := eccReader.ReadNext(eccBuffer); eRead < numRecovery {
+		return nil, nil, fmt.Errorf("%w: ecc file ended at chunk %d", filehelper.ErrGeometryMismatch, eccStart+eRead)
+	}
+	badEcc, eccErr := eccReader.Unreadable()
+	eDamages, eDetails := checkChunks(h.logger, meta, i, true, eccBuffer, crcs[numData:], numRecovery, badEcc, eccErr)
+	damage = &DamageDesc{i, dDamages, eDamages, append(details, eDetails...)}
+
+	h.mu.Lock()
+	err = h.repairer.fix(i, fileBuffer, eccBuffer, dDamages, eDamages, fRead)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, damage, err
+	}
+	// rebuilt chunks are new slices
+	for j := range fileBuffer {
+		copy(buffer[j*bs:], fileBuffer[j])
+	}
+	return buffer[:size], damage, nil
+}
@@ -0,0 +1,199 @@
+package decoding
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"github.com/klauspost/reedsolomon"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+type SectionStatus int
+
+const (
+	SectionOK SectionStatus = iota
+	SectionDamaged
+	SectionFailed // the section could not be checked, see Err
+)
+
+/**
+ * SectionEvent is what Walk found in a section. Data and Ecc are the chunks
+ * of the section as read, zeros in place of missing ones; they belong to
+ * the walk and are only valid until the callback returns.
+ */
+type SectionEvent struct {
+	Section 	int
+	Status 		SectionStatus
+	Damage 		*DamageDesc // set if Status is SectionDamaged
+	Err 		error // set if Status is SectionFailed
+	Data 		[][]byte
+	Ecc 		[][]byte
+	read 		int // data chunks read, the rest are missing
+}
+
+// SkipRest ends a Walk early without an error when returned by its callback
+var SkipRest = errors.New("skip the remaining sections")
+
+/**
+ * Repairer writes a repaired copy of the data file to an io.Writer, one
+ * section at a time. Passing Section as the callback of a Walk repairs the
+ * file in the same pass as the scan. Give the Walk the same Options, so
+ * that sectors found unreadable in salvage mode are known to the repair.
+ */
+type Repairer struct {
+	meta 		*types.Metadata
+	out 		io.Writer
+	salvage 	*filehelper.SalvageOptions
+	logger 		logging.Logger
+	encoders 	map[int]reedsolomon.Encoder
+	dataPages 	[][]byte
+	eccPages 	[][]byte
+	zero 		[]byte
+	next 		int // section to be written next
+	res 		RepairResult
+	err 		error // about the first section that failed
+}
+
+// NewRepairer returns a Repairer for a walk from section first, with the sections before it already in out
+func NewRepairer(opts *Options, meta *types.Metadata, out io.Writer, first int) *Repairer {
+	blockSize := int(meta.BlockSize)
+	r := &Repairer{meta: meta, out: out, salvage: opts.Salvage, logger: logging.New(opts.Log),
+		encoders: make(map[int]reedsolomon.Encoder), next: first, zero: make([]byte, blockSize)}
+	r.dataPages = make([][]byte, meta.NumData)
+	for i := range r.dataPages {
+		r.dataPages[i] = make([]byte, blockSize)
+	}
+	r.eccPages = make([][]byte, meta.MaxRecovery())
+	for i := range r.eccPages {
+		r.eccPages[i] = make([]byte, blockSize)
+	}
+	r.res.Repaired = make([]int, 0, 8)
+	return r
+}
+
+/**
+ * Section repairs and writes the section of ev, which has to follow the one
+ * before. Sections beyond repair are written as zeros and do not stop the
+ * repair, see Finish. A failed section is returned as its error.
+ */
+func (r *Repairer) Section(ev *SectionEvent) error {
+	if ev.Section != r.next {
+		return fmt.Errorf("section %d given for repair, expected %d", ev.Section, r.next)
+	}
+	if ev.Status == SectionFailed {
+		return ev.Err
+	}
+	data := ev.Data
+	if ev.Damage != nil && len(ev.Damage.DataDamage) != 0 {
+		// the buffers belong to the walk, rebuild in copies
+		data = load(r.dataPages, ev.Data)
+		ecc := load(r.eccPages, ev.Ecc)
+		r.record(ev.Section, r.fix(ev.Section, data, ecc, ev.Damage.DataDamage, ev.Damage.EccDamage, ev.read))
+	}
+	return r.write(ev.Section, data)
+}
+
+// Finish returns what was repaired, with a SectionError about the first section that could not be
+func (r *Repairer) Finish() (*RepairResult, error) {
+	r.logger.Info("Repair finished", logging.F("repaired", len(r.res.Repaired)), logging.F("failed", len(r.res.Failed)))
+	return &r.res, r.err
+}
+
+func load(pages [][]byte, chunks [][]byte) [][]byte {
+	buffer := make([][]byte, len(chunks))
+	for i, c := range chunks {
+		copy(pages[i], c)
+		buffer[i] = pages[i]
+	}
+	return buffer
+}
+
+func (r *Repairer) coder(numRecovery int) reedsolomon.Encoder {
+	enc, ok := r.encoders[numRecovery]
+	if !ok {
+		enc, _ = reedsolomon.New(int(r.meta.NumData), numRecovery)
+		r.encoders[numRecovery] = enc
+	}
+	return enc
+}
+
+// record adds the outcome of fix for section i to the result
+func (r *Repairer) record(i int, err error) {
+	if err == nil {
+		r.res.Repaired = append(r.res.Repaired, i)
+		return
+	}
+	r.res.Failed = append(r.res.Failed, i)
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+/**
+ * fix rebuilds the damaged chunks of section i in place, zeroing the section
+ * if it is beyond repair. Returns a SectionError if it could not be rebuilt
+ */
+func (r *Repairer) fix(i int, fileBuffer, eccBuffer [][]byte, dataDamage, eccDamage []int, chunksRead int) error {
+	numData := len(fileBuffer)
+	numRecovery := len(eccBuffer)
+	salvage := r.salvage
+
+	totalDmg := len(dataDamage) + len(eccDamage)
+	if r.meta.StalePadding(i) {
+		r.logger.Warn("Section cannot be repaired, the ecc file does not record its padding", logging.Section(i))
+		for i := range fileBuffer {
+			fileBuffer[i] = r.zero
+		}
+		return &SectionError{i, ErrStalePadding}
+	}
+	if totalDmg > numRecovery && salvage != nil && salvage.Map != nil &&
+		repairSectors(salvage, r.coder(numRecovery), fileBuffer, eccBuffer, dataDamage, eccDamage, int64(i)*r.meta.SectionSize(), chunksRead) {
+		r.logger.Info("Section repaired from readable sectors", logging.Section(i))
+		return nil
+	}
+	if totalDmg > numRecovery {
+		r.logger.Warn("Section damaged beyond repair", logging.Section(i), logging.F("damaged", totalDmg), logging.F("ecc", numRecovery))
+		for i := range fileBuffer {
+			fileBuffer[i] = r.zero
+		}
+		return &SectionError{i, ErrUnrecoverableSection}
+	}
+
+	// necessary and able to repair
+	for _,d := range dataDamage {
+		fileBuffer[d] = nil
+	}
+	for _,d := range eccDamage {
+		eccBuffer[d] = nil
+	}
+
+	enc := r.coder(numRecovery)
+	repairBuffer := make([][]byte, numData+numRecovery)
+	copy(repairBuffer, fileBuffer)
+	copy(repairBuffer[numData:], eccBuffer)
+	enc.Reconstruct(repairBuffer)
+	copy(fileBuffer, repairBuffer[:numData]) // copy back repaired chunks for writing
+	if ok, err := enc.Verify(repairBuffer); !ok || err != nil {
+		r.logger.Error("Reconstruction failed unexpectedly", logging.Section(i))
+		return &SectionError{i, ErrReconstruction}
+	}
+	return nil
+}
+
+func (r *Repairer) write(i int, fileBuffer [][]byte) error {
+	blockSize := int64(r.meta.BlockSize)
+	for j:=0; j<r.meta.DataChunksAt(i); j++ {
+		// without the padding of the last chunk
+		n := r.meta.FileSize - int64(i)*r.meta.SectionSize() - int64(j)*blockSize
+		if n > blockSize {
+			n = blockSize
+		}
+		if _, err := r.out.Write(fileBuffer[j][:n]); err != nil {
+			return err
+		}
+	}
+	r.next = i+1
+	return nil
+}
@@ -27,24 +27,103 @@ SOFTWARE.*/
 
 package encoding
 import (
-	"os"
-	"log"
+	"context"
+	"fmt"
+	"io"
 	"hash/crc32"
 	"github.com/klauspost/reedsolomon"
-	"alexhalogen/rsfileprotect/internal/filehelper"
-	"alexhalogen/rsfileprotect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 )
 
-func Encode(meta types.Metadata, inFile *os.File, eccFile *os.File, crcFile *os.File) bool {
+/**
+ * Options are the callbacks of EncodeWith, ResumeWith and NewStreamEncoder;
+ * Encode, EncodeContext and ResumeContext run without any. Checkpoint is
+ * called with the number of finished sections every CheckpointInterval
+ * sections and when encoding is interrupted, each time after the ecc and crc
+ * files have been synced. Encoding can be picked up from there with ResumeWith
+ */
+type Options struct {
+	Progress 			progress.Func // off if nil
+	Log 				logging.Func // receives what the encoding has to say, silent if nil
+	Checkpoint 			func(sections int) error // off if nil
+	CheckpointInterval 	int // 1024 if zero
+}
+
+/**
+ * Encode reads meta.FileSize bytes of data from inFile and writes the ecc
+ * and crc chunks to eccFile and crcFile, both starting at offset 0
+ */
+func Encode(meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt) error {
+	return EncodeContext(context.Background(), meta, inFile, eccFile, crcFile)
+}
+
+/**
+ * EncodeContext stops between two sections once ctx is done and returns
+ * ctx.Err(), the ecc and crc files are left incomplete in that case
+ */
+func EncodeContext(ctx context.Context, meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt) error {
+	return EncodeWith(ctx, &Options{}, meta, inFile, eccFile, crcFile)
+}
+
+func EncodeWith(ctx context.Context, opts *Options, meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt) error {
+	return encode(ctx, opts, meta, inFile, eccFile, crcFile, 0)
+}
+
+/**
+ * ResumeContext continues an interrupted encoding after its first sections.
+ * eccFile and crcFile hold the partial output, chunks past those sections are
+ * overwritten, and dropped if the files can be truncated
+ */
+func ResumeContext(ctx context.Context, meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt, sections int) error {
+	return ResumeWith(ctx, &Options{}, meta, inFile, eccFile, crcFile, sections)
+}
+
+func ResumeWith(ctx context.Context, opts *Options, meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt, sections int) error {
+	if sections < 0 || sections > meta.NumSections() {
+		return fmt.Errorf("cannot resume at section %d of %d", sections, meta.NumSections())
+	}
+	eccSize, crcSize := partialSizes(&meta, sections)
+	for _, f := range []struct{ file io.WriterAt; name string; size int64 }{{eccFile, "ecc", eccSize}, {crcFile, "crc", crcSize}} {
+		if size := filehelper.Size(f.file); size >= 0 && size < f.size {
+			return fmt.Errorf("%w: %s file is shorter than its checkpoint, %d of %d bytes", filehelper.ErrGeometryMismatch, f.name, size, f.size)
+		}
+		if t, ok := f.file.(interface{ Truncate(int64) error }); ok {
+			if err := t.Truncate(f.size); err != nil {
+				return err
+			}
+		}
+	}
+	return encode(ctx, opts, meta, inFile, eccFile, crcFile, sections)
+}
+
+// partialSizes returns the sizes of the ecc and crc files after their first sections
+func partialSizes(meta *types.Metadata, sections int) (int64, int64) {
+	eccChunks := int64(meta.EccChunkStart(sections))
+	eccSize := filehelper.HeaderSize + eccChunks*int64(meta.BlockSize)
+	crcSize := (int64(sections)*int64(meta.NumData) + eccChunks) * 4
+	return eccSize, crcSize
+}
+
+func encode(ctx context.Context, opts *Options, meta types.Metadata, inFile io.ReaderAt, eccFile io.WriterAt, crcFile io.WriterAt, first int) error {
 
 	bufferSize := int(meta.BlockSize)
 	numData := int(meta.NumData)
-	numRecovery := int(meta.NumRecovery)
+	maxRecovery := meta.MaxRecovery()
 
 	writer := filehelper.NewFileWriter(meta, eccFile, crcFile)
-	writer.WriteMeta()
-	bufferPages := make([][]byte, numData+numRecovery) // keeps buffer references
-	buffer := make([][]byte, numData+numRecovery) // buffer array used during calculation
+	if first == 0 {
+		// the header is written by Complete, so that partial files can't pass for complete ones
+		if err := writer.WritePlaceholder(); err != nil {
+			return err
+		}
+	} else {
+		writer.SetOffsets(partialSizes(&meta, first))
+	}
+	bufferPages := make([][]byte, numData+maxRecovery) // keeps buffer references
+	buffer := make([][]byte, numData+maxRecovery) // buffer array used during calculation
 	for arr := range buffer {
 		bufferPages[arr] = make([]byte, bufferSize)
 	}
@@ -52,56 +131,125 @@ func Encode(meta types.Metadata, inFile *os.File, eccFile *os.File, crcFile *os.
 	zero_page := make([]byte, bufferSize)
 	filehelper.Memset(zero_page, 0, bufferSize, 0)
 
-	enc, err := reedsolomon.New(numData, numRecovery)
+	encoders, err := coders(&meta)
 	if err != nil {
-		log.Printf("Coder initialization failed at (%d, %d)\n", numData, numRecovery)
-		return false
+		return err
 	}
 
 
-	cf := filehelper.NewChunkedReader(inFile, bufferSize, numData)
+	// only the first FileSize bytes are protected, whatever inFile holds after them
+	cf := filehelper.NewChunkedReader(io.NewSectionReader(inFile, 0, meta.FileSize), bufferSize, int64(first)*meta.SectionSize())
+	tracker := progress.NewTracker(opts.Progress, meta.FileSize)
+	tracker.Resume(int64(first) * meta.SectionSize())
+	logger := logging.New(opts.Log)
+	interval := opts.CheckpointInterval
+	if interval <= 0 {
+		interval = 1024
+	}
+	checkpoint := func(sections int) error {
+		if opts.Checkpoint == nil {
+			return nil
+		}
+		if err := writer.Sync(); err != nil {
+			return err
+		}
+		if err := opts.Checkpoint(sections); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		return nil
+	}
 	
-	for {
+	for section:=first; ; section++ {
+		if ctx.Err() != nil {
+			logger.Warn("Encoding interrupted", logging.Section(section), logging.Err(ctx.Err()))
+			if err := checkpoint(section); err != nil {
+				logger.Error("Checkpoint not saved", logging.Err(err))
+			}
+			return ctx.Err()
+		}
+		numRecovery := meta.RecoveryAt(section)
+		enc := encoders[numRecovery]
+		buffer := buffer[:numData+numRecovery]
+
 		copy(buffer, bufferPages)
 		var chunksRead int
 		chunksRead, eof := cf.ReadNext(buffer[0:numData])
+		tracker.IODone()
 		if eof {
 			break
 		}
+		if bad, err := cf.Unreadable(); len(bad) != 0 {
+			return fmt.Errorf("cannot read data chunk %d: %w", section*numData+bad[0], err)
+		}
 		if chunksRead != numData {
-			for i:=chunksRead+1; i<numData; i++ {
+			// zeros only, LegacyPadding marks files where the first padding chunk was left as is
+			for i:=chunksRead; i<numData; i++ {
 				buffer[i] = zero_page
 			}
 		}
 
-		err = enc.Encode(buffer)
-		
-		if err != nil {
-			log.Println("Encoding failed!")
-			return false
-		}
-
-		ok, err := enc.Verify(buffer)
-
-		if err != nil || !ok {
-			log.Println("Encoding verification failed!")
-			return false
+		if err := encodeSection(enc, writer, tracker, section, buffer, numData); err != nil {
+			return err
 		}
-		err =  writer.WriteECCChunk(buffer[numData:])
-		if err != nil {
-			log.Println(err)
-			return false
-		}
-		eccs := make([]uint32, len(buffer))
-		for i:=0; i<len(buffer); i++ {
-			eccs[i] = crc32.ChecksumIEEE(buffer[i])
+		if (section+1) % interval == 0 {
+			if err := checkpoint(section+1); err != nil {
+				return err
+			}
 		}
-		err = writer.WriteCRCChunk(eccs)
+		tracker.IODone()
+		tracker.Advance(int64(section+1) * meta.SectionSize())
+	}
+	if err := writer.WriteTrailer(); err != nil {
+		return err
+	}
+	if err := writer.Complete(); err != nil {
+		return err
+	}
+	logger.Info("Encoding finished", logging.F("sections", meta.NumSections()), logging.F("resumed", first))
+	tracker.IODone()
+	tracker.Finish()
+	return nil
+}
+
+/**
+ * encodeSection computes the ecc chunks of a section into buffer after its
+ * numData data chunks and writes them with the crcs of all chunks
+ */
+func encodeSection(enc reedsolomon.Encoder, writer *filehelper.FileWriter, tracker *progress.Tracker, section int, buffer [][]byte, numData int) error {
+	if err := enc.Encode(buffer); err != nil {
+		return fmt.Errorf("encoding section %d failed: %w", section, err)
+	}
+	if ok, err := enc.Verify(buffer); err != nil || !ok {
+		return fmt.Errorf("verification of section %d failed", section)
+	}
+	eccs := make([]uint32, len(buffer))
+	for i:=0; i<len(buffer); i++ {
+		eccs[i] = crc32.ChecksumIEEE(buffer[i])
+	}
+	tracker.CodingDone()
+	if err := writer.WriteECCChunk(buffer[numData:]); err != nil {
+		return err
+	}
+	return writer.WriteCRCChunk(eccs)
+}
+
+// coders returns one coder for each distinct number of ecc chunks used by meta
+func coders(meta *types.Metadata) (map[int]reedsolomon.Encoder, error) {
+	encoders := make(map[int]reedsolomon.Encoder)
+	for _, nr := range append([]int{int(meta.NumRecovery)}, recoveryLevels(meta)...) {
+		enc, err := reedsolomon.New(int(meta.NumData), nr)
 		if err != nil {
-			log.Println(err)
-			return false
+			return nil, fmt.Errorf("coder initialization failed at (%d, %d): %w", meta.NumData, nr, err)
 		}
+		encoders[nr] = enc
+	}
+	return encoders, nil
+}
+
+func recoveryLevels(meta *types.Metadata) []int {
+	levels := make([]int, len(meta.Regions))
+	for i, r := range meta.Regions {
+		levels[i] = int(r.NumRecovery)
 	}
-	writer.Sync()
-	return true
-}
\ No newline at end of file
+	return levels
+}
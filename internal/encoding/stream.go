@@ -0,0 +1,150 @@
+package encoding
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"github.com/klauspost/reedsolomon"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+var errClosed = errors.New("write to a closed stream encoder")
+
+/**
+ * StreamEncoder protects data of unknown length as it is written, in a single
+ * pass. The chunks of a section are written to the ecc and crc files once it
+ * is full, those of the last one on Close. Close also records the size and
+ * the sha256 digest of the data, and writes the header that marks the ecc
+ * file complete; an encoder that is never closed leaves it incomplete.
+ */
+type StreamEncoder struct {
+	meta 		types.Metadata
+	writer 		*filehelper.FileWriter
+	encoders 	map[int]reedsolomon.Encoder
+	section 	[]byte // data of the current section
+	buffer 		[][]byte // chunks of section followed by ecc chunks
+	filled 		int // bytes in section
+	sections 	int // sections written
+	size 		int64
+	digest 		hash.Hash
+	tracker 	*progress.Tracker
+	logger 		logging.Logger
+	err 		error // of the first failed write, returned from then on
+	closed 		bool
+}
+
+// NewStreamEncoder starts a stream encoding with the geometry of meta, its FileSize is set by Close
+func NewStreamEncoder(opts *Options, meta types.Metadata, eccFile io.WriterAt, crcFile io.WriterAt) (*StreamEncoder, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	meta.FileSize = 0
+	encoders, err := coders(&meta)
+	if err != nil {
+		return nil, err
+	}
+	s := &StreamEncoder{meta: meta, writer: filehelper.NewFileWriter(meta, eccFile, crcFile), encoders: encoders,
+		digest: sha256.New(), tracker: progress.NewTracker(opts.Progress, -1), logger: logging.New(opts.Log)}
+	// the header is written by Close, so that partial files can't pass for complete ones
+	if err := s.writer.WritePlaceholder(); err != nil {
+		return nil, err
+	}
+
+	bufferSize := int(meta.BlockSize)
+	numData := int(meta.NumData)
+	s.section = make([]byte, numData*bufferSize)
+	s.buffer = make([][]byte, numData+meta.MaxRecovery())
+	for i := range s.buffer {
+		if i < numData {
+			s.buffer[i] = s.section[i*bufferSize : (i+1)*bufferSize]
+		} else {
+			s.buffer[i] = make([]byte, bufferSize)
+		}
+	}
+	return s, nil
+}
+
+func (s *StreamEncoder) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errClosed
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := 0
+	for len(p) > 0 {
+		c := copy(s.section[s.filled:], p)
+		s.digest.Write(p[:c])
+		s.filled += c
+		s.size += int64(c)
+		n += c
+		p = p[c:]
+		if s.filled == len(s.section) {
+			if err := s.flush(); err != nil {
+				s.err = err
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush encodes and writes the current section, zero-padded after the data written so far
+func (s *StreamEncoder) flush() error {
+	filehelper.Memset(s.section, 0, len(s.section)-s.filled, s.filled)
+	numData := int(s.meta.NumData)
+	numRecovery := s.meta.RecoveryAt(s.sections)
+	s.tracker.IODone()
+	if err := encodeSection(s.encoders[numRecovery], s.writer, s.tracker, s.sections, s.buffer[:numData+numRecovery], numData); err != nil {
+		return err
+	}
+	s.tracker.IODone()
+	s.sections++
+	s.filled = 0
+	s.tracker.Advance(s.size)
+	return nil
+}
+
+// Close encodes the last section and completes the ecc file
+func (s *StreamEncoder) Close() error {
+	if s.closed {
+		return errClosed
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	if s.filled > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.meta.FileSize = s.size
+	copy(s.meta.Digest[:], s.digest.Sum(nil))
+	s.writer.SetMeta(s.meta)
+	if err := s.writer.WriteTrailer(); err != nil {
+		return err
+	}
+	if err := s.writer.Complete(); err != nil {
+		return err
+	}
+	s.logger.Info("Encoding finished", logging.F("sections", s.sections), logging.F("size", s.size))
+	s.tracker.IODone()
+	s.tracker.Total = s.size
+	s.tracker.Finish()
+	return nil
+}
+
+// Size returns the number of bytes written so far
+func (s *StreamEncoder) Size() int64 {
+	return s.size
+}
+
+// Meta returns the metadata written to the ecc file, complete after Close
+func (s *StreamEncoder) Meta() types.Metadata {
+	return s.meta
+}
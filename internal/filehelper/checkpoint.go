@@ -0,0 +1,103 @@
+package filehelper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+/*
+Checkpoints of interrupted encodings are kept in a small file of their own:
+
+  { Magic [8]byte, Sections int64, ModTime int64, MetaLength uint32 }
+  metadata as stored in the ecc file: header followed by the trailer, if any
+
+The ecc and crc files hold everything up to Sections, anything after that
+is discarded on resume.
+*/
+
+var checkpointMagic = [8]byte{'R', 'S', 'F', 'P', 'C', 'K', 'P', 'T'}
+
+type checkpointHeader struct {
+	Magic 		[8]byte
+	Sections 	int64
+	ModTime 	int64
+	MetaLength 	uint32
+}
+
+type Checkpoint struct {
+	Meta 		types.Metadata
+	Sections 	int // sections whose ecc and crc chunks are on disk
+	ModTime 	int64 // of the data file, to detect changes before resuming
+}
+
+var errBadCheckpoint = errors.New("malformed checkpoint file")
+
+// WriteCheckpoint replaces the checkpoint file name atomically
+func WriteCheckpoint(name string, ckpt *Checkpoint) error {
+	var metaBuf bytes.Buffer
+	binary.Write(&metaBuf, binary.LittleEndian, headerOf(&ckpt.Meta))
+	metaBuf.Write(encodeTrailer(&ckpt.Meta))
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, checkpointHeader{checkpointMagic, int64(ckpt.Sections), ckpt.ModTime, uint32(metaBuf.Len())})
+	buf.Write(metaBuf.Bytes())
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func ReadCheckpoint(name string) (*Checkpoint, error) {
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(contents)
+	var ch checkpointHeader
+	if err := binary.Read(r, binary.LittleEndian, &ch); err != nil || ch.Magic != checkpointMagic {
+		return nil, errBadCheckpoint
+	}
+	if int64(ch.MetaLength) != int64(r.Len()) || int64(ch.MetaLength) < HeaderSize || ch.Sections < 0 {
+		return nil, errBadCheckpoint
+	}
+
+	ckpt := &Checkpoint{Sections: int(ch.Sections), ModTime: ch.ModTime}
+	var h header
+	binary.Read(r, binary.LittleEndian, &h)
+	h.copyTo(&ckpt.Meta)
+
+	rest := contents[len(contents)-r.Len():]
+	if len(rest) != 0 {
+		if int64(len(rest)) < footerSize {
+			return nil, errBadCheckpoint
+		}
+		var ft footer
+		binary.Read(bytes.NewReader(rest[int64(len(rest))-footerSize:]), binary.LittleEndian, &ft)
+		if ft.Magic != trailerMagic || int64(ft.Length)+footerSize != int64(len(rest)) {
+			return nil, errBadCheckpoint
+		}
+		if err := decodeTrailer(rest[:ft.Length], &ckpt.Meta); err != nil {
+			return nil, err
+		}
+	}
+	return ckpt, nil
+}
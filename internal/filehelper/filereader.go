@@ -1,16 +1,23 @@
 package filehelper
 
 import (
+	"io"
 	"os"
 	"bufio"
+	"math"
 	"encoding/binary"
-	"alexhalogen/rsfileprotect/internal/types"
+	"fmt"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 )
 
 type ChunkedReader struct {
-	file *os.File
+	file io.ReaderAt
 	chunkSize int
-	offset int
+	offset int64 // position of the next chunk
+	size int64 // -1 if unknown
+	unreadable []int // chunks of the last batch that could not be read
+	readErr error
+	salvage *SalvageOptions
 }
 
 type CRCReader struct {
@@ -18,22 +25,43 @@ type CRCReader struct {
 	buffer []byte
 }
 
-func NewChunkedReader(f *os.File, cs int, offset int) (ChunkedReader) {
-	cf := ChunkedReader{file: f, chunkSize: cs, offset: offset}
-	return cf
+/**
+ * Reads chunks with ReadAt starting at offset, so that a failed read does not
+ * stop the chunks after it from being read. Several readers may share f
+ */
+func NewChunkedReader(f io.ReaderAt, cs int, offset int64) (*ChunkedReader) {
+	return &ChunkedReader{file: f, chunkSize: cs, offset: offset, size: Size(f)}
 }
 
-func NewCRCReader(f *os.File, size int) (CRCReader) {
+// NewCRCReader reads crcs from f starting at offset, buffering size bytes
+func NewCRCReader(f io.ReaderAt, offset int64, size int) (CRCReader) {
 	var reader CRCReader
 	reader.buffer = make([]byte, 4)
+	sr := io.NewSectionReader(f, offset, math.MaxInt64-offset)
 	if size == 0 {
-		reader.file = bufio.NewReader(f)
+		reader.file = bufio.NewReader(sr)
 	} else {
-		reader.file = bufio.NewReaderSize(f, size)
+		reader.file = bufio.NewReaderSize(sr, size)
 	}
 	return reader
 }
 
+/**
+ * Size returns the size of f if it can tell, from a Size method as on
+ * bytes.Reader and io.SectionReader or from Stat for regular files; -1 otherwise
+ */
+func Size(f interface{}) int64 {
+	switch v := f.(type) {
+		case interface{ Size() int64 }:
+			return v.Size()
+		case interface{ Stat() (os.FileInfo, error) }:
+			if fs, err := v.Stat(); err == nil && fs.Mode().IsRegular() {
+				return fs.Size()
+			}
+	}
+	return -1
+}
+
 func (cr CRCReader) ReadNext(out []uint32) (int, error) {
 	for i, _ := range out {
 		err := binary.Read(cr.file, binary.LittleEndian, cr.buffer)
@@ -45,48 +73,89 @@ func (cr CRCReader) ReadNext(out []uint32) (int, error) {
 	return len(out), nil
 }
 
-func (cf ChunkedReader) ReadNext(buffer [][]byte) (chunksRead int, eof bool){
+/**
+ * Fills buffer with the next chunks, zero-padding the last one at end of file.
+ * Chunks that fail to read (bad sectors, checksum errors of the filesystem..)
+ * are zeroed and still counted, see Unreadable
+ */
+func (cf *ChunkedReader) ReadNext(buffer [][]byte) (chunksRead int, eof bool){
+	cf.unreadable = cf.unreadable[:0]
+	cf.readErr = nil
 	numChunks := len(buffer)
 	if numChunks == 0 {
 		return 0, false
 	}
-	bufferSize := cap(buffer[0])
-	eof = false
-	lastChunk := -1
-	lastSize := 0
 
 	for i:=0; i<numChunks; i++ {
-		bytesRead, err := cf.file.Read(buffer[i])
-		if err != nil {
-			if bytesRead != 0 {
-				print(err.Error())	// non-eof
+		if cf.size >= 0 && cf.offset >= cf.size {
+			break
+		}
+		bufferSize := len(buffer[i])
+		bytesRead, err := cf.file.ReadAt(buffer[i], cf.offset)
+		if err != nil && err != io.EOF && cf.salvage != nil {
+			var lost bool
+			bytesRead, lost, err = SalvageChunk(cf.file, cf.size, buffer[i], cf.offset, cf.salvage)
+			if lost {
+				// still an erasure, but its readable sectors are kept
+				cf.unreadable = append(cf.unreadable, i)
+				if cf.readErr == nil {
+					cf.readErr = err
+				}
+				err = nil
 			}
-			if i == 0 {
-				eof = true
-				chunksRead = 0
-				return
+		}
+		if err != nil && err != io.EOF {
+			cf.unreadable = append(cf.unreadable, i)
+			if cf.readErr == nil {
+				cf.readErr = err
 			}
+			bytesRead = bufferSize
+			Memset(buffer[i], 0, bufferSize, 0)
+		} else if bytesRead == 0 {
+			break
+		}
+
+		cf.offset += int64(cf.chunkSize)
+		chunksRead = i+1
+		if bytesRead != bufferSize {
+			// fill unread portion in last chunk with zeros
+			Memset(buffer[i], 0, bufferSize-bytesRead, bytesRead)
 			break
-		} else {
-			lastSize = bytesRead
-			lastChunk = i
 		}
-	}
-	// fill unread portion in lastChunk with zeros
-	if lastSize != bufferSize {
-		Memset(buffer[lastChunk], 0, bufferSize-lastSize, lastSize)
 	}
 
-	chunksRead = lastChunk+1
+	eof = chunksRead == 0
 	return
 }
 
-func (cf ChunkedReader) SkipNext(chunks int, chunkSize int) (error){
-	_, err := cf.file.Seek( int64(chunks*chunkSize), 1)
-	return err
+// Unreadable returns chunks of the last ReadNext that could not be read and the first error
+func (cf *ChunkedReader) Unreadable() ([]int, error) {
+	return cf.unreadable, cf.readErr
+}
+
+func (cf *ChunkedReader) SkipNext(chunks int, chunkSize int) (error){
+	cf.offset += int64(chunks*chunkSize)
+	return nil
 }
 
 
-func ReadMeta(f *os.File, meta *types.Metadata) (error) {
-	return binary.Read(f, binary.LittleEndian, meta)
-}
\ No newline at end of file
+/**
+ * ReadMeta reads the header and trailer of an ecc file. Errors wrap
+ * ErrBadHeader if they are not I/O errors, except for ErrIncomplete
+ */
+func ReadMeta(f io.ReaderAt, meta *types.Metadata) (error) {
+	var h header
+	err := binary.Read(io.NewSectionReader(f, 0, HeaderSize), binary.LittleEndian, &h)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: file is shorter than the header", ErrBadHeader)
+	}
+	if err != nil {
+		return err
+	}
+	if h == (header{}) {
+		return ErrIncomplete
+	}
+	*meta = types.Metadata{}
+	h.copyTo(meta)
+	return readTrailer(f, meta)
+}
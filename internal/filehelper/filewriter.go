@@ -1,44 +1,116 @@
 package filehelper
 
 import (
-	"os"
+	"io"
 	"bufio"
+	"bytes"
 	// "fmt"
 	"encoding/binary"
-	"alexhalogen/rsfileprotect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 )
+
+/**
+ * FileWriter writes ecc chunks at increasing offsets of eccFile and crcs at
+ * increasing offsets of crcFile, both starting right after the header unless
+ * moved with SetOffsets
+ */
 type FileWriter struct {
-	eccFile *os.File
+	eccFile io.WriterAt
 	crcFile *bufio.Writer
+	crcRaw io.WriterAt
+	crc 	*offsetWriter
+	eccOffset	int64
 	meta	types.Metadata
 	count	int64 // for use in superblock backup?
 }
 
-func NewFileWriter(meta types.Metadata, eccFile *os.File, crcFile *os.File) (fw FileWriter){
+// offsetWriter turns sequential writes into WriteAt calls
+type offsetWriter struct {
+	w io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+func NewFileWriter(meta types.Metadata, eccFile io.WriterAt, crcFile io.WriterAt) (*FileWriter){
+	fw := &FileWriter{meta: meta, eccFile: eccFile, crcRaw: crcFile, eccOffset: HeaderSize}
+	fw.crc = &offsetWriter{w: crcFile}
+	fw.crcFile = bufio.NewWriter(fw.crc)
+	return fw
+}
+
+// SetOffsets moves the writer to the given offsets, e.g. to continue a partial file
+func (fw *FileWriter)SetOffsets(ecc, crc int64) (error){
+	if err := fw.crcFile.Flush(); err != nil {
+		return err
+	}
+	fw.eccOffset = ecc
+	fw.crc.off = crc
+	return nil
+}
+
+// SetMeta replaces the metadata written by WriteTrailer and Complete, e.g. once the size of a stream is known
+func (fw *FileWriter)SetMeta(meta types.Metadata) {
 	fw.meta = meta
-	fw.eccFile = eccFile
-	fw.crcFile = bufio.NewWriter(crcFile)
-	return
 }
 
-func (fw FileWriter)WriteMeta() (error){
-	return binary.Write(fw.eccFile, binary.LittleEndian, fw.meta)
+func (fw *FileWriter)WriteMeta() (error){
+	return fw.writeHeader(headerOf(&fw.meta))
+}
+
+func (fw *FileWriter)writeHeader(h header) (error){
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, h)
+	_, err := fw.eccFile.WriteAt(buf.Bytes(), 0)
+	return err
+}
+
+/**
+ * WritePlaceholder reserves room for the header with zeros, which ReadMeta
+ * reports as ErrIncomplete until Complete writes the real header
+ */
+func (fw *FileWriter)WritePlaceholder() (error){
+	return fw.writeHeader(header{})
+}
+
+// Complete syncs everything written so far, then writes the header in place of the placeholder
+func (fw *FileWriter)Complete() (error){
+	if err := fw.Sync(); err != nil {
+		return err
+	}
+	if err := fw.WriteMeta(); err != nil {
+		return err
+	}
+	return syncFile(fw.eccFile)
+}
+
+// WriteTrailer must be called after the last ecc chunk has been written
+func (fw *FileWriter)WriteTrailer() (error) {
+	trailer := encodeTrailer(&fw.meta)
+	_, err := fw.eccFile.WriteAt(trailer, fw.eccOffset)
+	fw.eccOffset += int64(len(trailer))
+	return err
 }
-func (fw FileWriter)WriteECCChunk(eccs [][]byte) (error) {
+func (fw *FileWriter)WriteECCChunk(eccs [][]byte) (error) {
 	/*if fw.count == 114514 {
 		fmt.Println("Creating Additional metadata backup")
 	}*/
 	for _, entry := range eccs {
-		_, err := fw.eccFile.Write(entry)
+		_, err := fw.eccFile.WriteAt(entry, fw.eccOffset)
 		if err != nil {
 			return err
 		}
+		fw.eccOffset += int64(len(entry))
 	}
 	fw.count += 1
 	return nil
 }
 
-func (fw FileWriter)WriteCRCChunk(crcs []uint32) (error) {
+func (fw *FileWriter)WriteCRCChunk(crcs []uint32) (error) {
 	for _, crc := range crcs {
 		err := binary.Write(fw.crcFile, binary.LittleEndian, crc)
 		if err != nil {
@@ -48,7 +120,20 @@ func (fw FileWriter)WriteCRCChunk(crcs []uint32) (error) {
 	return nil
 }
 
-func (fw FileWriter)Sync() {
-	fw.eccFile.Sync()
-	fw.crcFile.Flush()
-}
\ No newline at end of file
+// Sync flushes buffered crcs and commits both files to disk if they support it
+func (fw *FileWriter)Sync() (error) {
+	if err := fw.crcFile.Flush(); err != nil {
+		return err
+	}
+	if err := syncFile(fw.crcRaw); err != nil {
+		return err
+	}
+	return syncFile(fw.eccFile)
+}
+
+func syncFile(f interface{}) error {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
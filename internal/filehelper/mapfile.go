@@ -0,0 +1,58 @@
+package filehelper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Adds the ranges of a GNU ddrescue mapfile that were not rescued, i.e. all
+ * blocks whose status is anything but '+'. The first non-comment line holds
+ * the state of ddrescue itself and is skipped
+ */
+func (m *BadMap) ReadDdrescue(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	statusLine := true
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if statusLine {
+			statusLine = false
+			continue
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("mapfile line %d: expected pos, size and status", line)
+		}
+		pos, err1 := strconv.ParseInt(fields[0], 0, 64)
+		size, err2 := strconv.ParseInt(fields[1], 0, 64)
+		if err1 != nil || err2 != nil || pos < 0 || size < 0 {
+			return fmt.Errorf("mapfile line %d: invalid block %s %s", line, fields[0], fields[1])
+		}
+		if fields[2] != "+" {
+			m.Add(pos, pos+size)
+		}
+	}
+	return scanner.Err()
+}
+
+// Adds the blocks listed by badblocks, one block number per line
+func (m *BadMap) ReadBadblocks(r io.Reader, blockSize int64) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		block, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || block < 0 {
+			return fmt.Errorf("badblocks line %d: invalid block number %q", line, s)
+		}
+		m.Add(block*blockSize, (block+1)*blockSize)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,140 @@
+package filehelper
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// SalvageOptions controls how a ChunkedReader deals with chunks that fail to read
+type SalvageOptions struct {
+	Retries 	int // extra attempts before a read is given up
+	SectorSize 	int // size of the reads used once a whole chunk keeps failing
+	Marker 		byte // fills sectors that could not be read
+	Map 		*BadMap // records unreadable byte ranges if not nil
+}
+
+// ByteRange is the range [Start, End) of a file
+type ByteRange struct {
+	Start 	int64
+	End 	int64
+}
+
+/**
+ * BadMap is a sorted list of non-overlapping byte ranges that could not be
+ * read. Add, Overlaps and Size may be called from several goroutines, e.g.
+ * readers of different sections sharing one map; Ranges is only safe to use
+ * once they are done.
+ */
+type BadMap struct {
+	Ranges 	[]ByteRange
+	mu 		sync.Mutex
+}
+
+func (m *BadMap) Add(start, end int64) {
+	if end <= start {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// first range that ends at or after start, all before it stay untouched
+	i := sort.Search(len(m.Ranges), func(i int) bool { return m.Ranges[i].End >= start })
+	j := i
+	for j < len(m.Ranges) && m.Ranges[j].Start <= end {
+		if m.Ranges[j].Start < start {
+			start = m.Ranges[j].Start
+		}
+		if m.Ranges[j].End > end {
+			end = m.Ranges[j].End
+		}
+		j++
+	}
+	merged := append([]ByteRange{}, m.Ranges[:i]...)
+	merged = append(merged, ByteRange{start, end})
+	m.Ranges = append(merged, m.Ranges[j:]...)
+}
+
+// Overlaps reports whether any byte in [start, end) is unreadable
+func (m *BadMap) Overlaps(start, end int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := sort.Search(len(m.Ranges), func(i int) bool { return m.Ranges[i].End > start })
+	return i < len(m.Ranges) && m.Ranges[i].Start < end
+}
+
+// Size returns the total number of unreadable bytes
+func (m *BadMap) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, r := range m.Ranges {
+		total += r.End - r.Start
+	}
+	return total
+}
+
+// SetSalvage makes failed chunks go through retries and sector-sized reads
+func (cf *ChunkedReader) SetSalvage(opts *SalvageOptions) {
+	cf.salvage = opts
+}
+
+/**
+ * Reads a chunk the hard way: retry it as a whole, then sector by sector so
+ * that only the sectors that still fail are lost. Lost sectors are filled
+ * with opts.Marker, up to size if it is known. Returns the number of bytes
+ * covered and whether any sector was lost
+ */
+func SalvageChunk(r io.ReaderAt, size int64, buf []byte, off int64, opts *SalvageOptions) (int, bool, error) {
+	var err error
+	for attempt:=0; attempt<opts.Retries; attempt++ {
+		var n int
+		n, err = r.ReadAt(buf, off)
+		if err == nil || err == io.EOF {
+			return n, false, err
+		}
+	}
+
+	ss := opts.SectorSize
+	if ss <= 0 {
+		ss = len(buf)
+	}
+	bytesRead := 0
+	lost := false
+	var firstErr error
+	for p:=0; p<len(buf); p+=ss {
+		end := p+ss
+		if end > len(buf) {
+			end = len(buf)
+		}
+		var n int
+		for attempt:=0; attempt<=opts.Retries; attempt++ {
+			n, err = r.ReadAt(buf[p:end], off+int64(p))
+			if err == nil || err == io.EOF {
+				break
+			}
+		}
+		if err != nil && err != io.EOF {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if size >= 0 && off+int64(end) > size {
+				end = int(size - off)
+			}
+			Memset(buf[p:end], opts.Marker, end-p, 0)
+			if opts.Map != nil {
+				opts.Map.Add(off+int64(p), off+int64(end))
+			}
+			lost = true
+			bytesRead = end
+			if size >= 0 && off+int64(end) >= size {
+				break // end of file
+			}
+			continue
+		}
+		bytesRead = p+n
+		if n < end-p {
+			break // end of file
+		}
+	}
+	return bytesRead, lost, firstErr
+}
@@ -0,0 +1,68 @@
+package filehelper
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var errBackwards = errors.New("stream cannot be read backwards")
+
+/**
+ * SequentialReaderAt serves ReadAt from a stream such as a pipe, for readers
+ * that only move forward. Bytes skipped over are discarded and reads before
+ * the current position fail. It has no size, see Size.
+ */
+type SequentialReaderAt struct {
+	r 		io.Reader
+	pos 	int64
+	err 	error // first error other than io.EOF, returned from then on
+}
+
+func NewSequentialReaderAt(r io.Reader) *SequentialReaderAt {
+	return &SequentialReaderAt{r: r}
+}
+
+func (s *SequentialReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if off < s.pos {
+		return 0, errBackwards
+	}
+	if off > s.pos {
+		n, err := io.CopyN(ioutil.Discard, s.r, off-s.pos)
+		s.pos += n
+		if err != nil {
+			return 0, s.fail(err)
+		}
+	}
+	n, err := io.ReadFull(s.r, p)
+	s.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, s.fail(err)
+}
+
+// Drain reads the rest of the stream and returns its total size
+func (s *SequentialReaderAt) Drain() (int64, error) {
+	if s.err != nil {
+		return s.pos, s.err
+	}
+	n, err := io.Copy(ioutil.Discard, s.r)
+	s.pos += n
+	return s.pos, s.fail(err)
+}
+
+// Err returns the first error reading the stream, other than io.EOF
+func (s *SequentialReaderAt) Err() error {
+	return s.err
+}
+
+func (s *SequentialReaderAt) fail(err error) error {
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+package filehelper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+/*
+Ecc files start with a fixed-size header. Everything that does not fit in
+there is kept in an optional trailer right after the last ecc chunk:
+
+  records: { Tag uint16, Length uint32, Value [Length]byte } ...
+  footer:  { Length uint32, Version uint16, Reserved uint16, Magic [8]byte }
+
+Length in the footer is the total size of all records. Files written by
+older versions end right after the last ecc chunk and have no trailer.
+
+Version 2 is written to every file, with or without records. It tells that
+the last section was padded with zeros only; files with an older trailer or
+none are read with LegacyPadding set.
+
+The header is written last. Until then it is all zeros, which marks files
+whose encoding never finished, see ErrIncomplete.
+*/
+
+const trailerVersion = 2
+
+var trailerMagic = [8]byte{'R', 'S', 'F', 'P', 'T', 'R', 'L', 0x89}
+
+const (
+	tagRegions uint16 = 1
+	tagCreated uint16 = 2 // { Time int64, Creator, DataName } with strings as { Length uint16, [Length]byte }
+	tagDigest uint16 = 3 // sha256 of the data [32]byte
+)
+
+// header is the fixed-size part of types.Metadata stored at the start of ecc files
+type header struct {
+	FileSize 		int64
+	BlockSize 		int32
+	NumData 		uint16
+	NumRecovery 	uint16
+	Ecc				[16]byte
+}
+
+type footer struct {
+	Length 		uint32
+	Version 	uint16
+	Reserved 	uint16
+	Magic 		[8]byte
+}
+
+type recordHeader struct {
+	Tag 	uint16
+	Length 	uint32
+}
+
+type regionRecord struct {
+	Start 		int64
+	End 		int64
+	NumRecovery uint16
+	Reserved 	[6]byte
+}
+
+var HeaderSize = int64(binary.Size(header{}))
+var footerSize = int64(binary.Size(footer{}))
+
+var (
+	// ErrBadHeader is wrapped by errors about ecc files whose metadata cannot be read
+	ErrBadHeader = errors.New("bad ecc file header")
+	// ErrGeometryMismatch is wrapped by errors about files that do not fit the metadata
+	ErrGeometryMismatch = errors.New("files do not match the geometry in the ecc header")
+	ErrUnknownSize = errors.New("cannot tell the size of the file")
+	ErrIncomplete = errors.New("ecc file is incomplete, encoding did not finish")
+)
+
+var errBadTrailer = fmt.Errorf("%w: malformed trailer", ErrBadHeader)
+
+func headerOf(meta *types.Metadata) header {
+	return header{meta.FileSize, meta.BlockSize, meta.NumData, meta.NumRecovery, meta.Ecc}
+}
+
+func (h header) copyTo(meta *types.Metadata) {
+	meta.FileSize = h.FileSize
+	meta.BlockSize = h.BlockSize
+	meta.NumData = h.NumData
+	meta.NumRecovery = h.NumRecovery
+	meta.Ecc = h.Ecc
+}
+
+func writeRecord(buf *bytes.Buffer, tag uint16, value interface{}) {
+	binary.Write(buf, binary.LittleEndian, recordHeader{tag, uint32(binary.Size(value))})
+	binary.Write(buf, binary.LittleEndian, value)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var l uint16
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return "", errBadTrailer
+	}
+	if int(l) > r.Len() {
+		return "", errBadTrailer
+	}
+	s := make([]byte, l)
+	r.Read(s)
+	return string(s), nil
+}
+
+// encodeTrailer returns the records of meta and the footer
+func encodeTrailer(meta *types.Metadata) []byte {
+	var records bytes.Buffer
+
+	if len(meta.Regions) != 0 {
+		regions := make([]regionRecord, len(meta.Regions))
+		for i, r := range meta.Regions {
+			regions[i] = regionRecord{Start: r.Start, End: r.End, NumRecovery: r.NumRecovery}
+		}
+		writeRecord(&records, tagRegions, regions)
+	}
+
+	if meta.Created != 0 || meta.Creator != "" || meta.DataName != "" {
+		var created bytes.Buffer
+		binary.Write(&created, binary.LittleEndian, meta.Created)
+		writeString(&created, meta.Creator)
+		writeString(&created, meta.DataName)
+		writeRecord(&records, tagCreated, created.Bytes())
+	}
+
+	if meta.Digest != [32]byte{} {
+		writeRecord(&records, tagDigest, meta.Digest)
+	}
+
+	binary.Write(&records, binary.LittleEndian, footer{
+		Length: uint32(records.Len()), Version: trailerVersion, Magic: trailerMagic})
+	return records.Bytes()
+}
+
+func decodeTrailer(records []byte, meta *types.Metadata) error {
+	r := bytes.NewReader(records)
+	for r.Len() > 0 {
+		var rh recordHeader
+		if err := binary.Read(r, binary.LittleEndian, &rh); err != nil {
+			return errBadTrailer
+		}
+		if int64(rh.Length) > int64(r.Len()) {
+			return errBadTrailer
+		}
+		value := make([]byte, rh.Length)
+		r.Read(value)
+
+		switch rh.Tag {
+		case tagRegions:
+			regions := make([]regionRecord, len(value)/binary.Size(regionRecord{}))
+			if err := binary.Read(bytes.NewReader(value), binary.LittleEndian, regions); err != nil {
+				return errBadTrailer
+			}
+			meta.Regions = make([]types.Region, len(regions))
+			for i, rr := range regions {
+				meta.Regions[i] = types.Region{Start: rr.Start, End: rr.End, NumRecovery: rr.NumRecovery}
+			}
+		case tagCreated:
+			vr := bytes.NewReader(value)
+			if err := binary.Read(vr, binary.LittleEndian, &meta.Created); err != nil {
+				return errBadTrailer
+			}
+			var err error
+			if meta.Creator, err = readString(vr); err != nil {
+				return err
+			}
+			if meta.DataName, err = readString(vr); err != nil {
+				return err
+			}
+		case tagDigest:
+			if len(value) != len(meta.Digest) {
+				return errBadTrailer
+			}
+			copy(meta.Digest[:], value)
+		default:
+			// written by a newer version, not needed for decoding
+		}
+	}
+	return nil
+}
+
+// Layout describes how an ecc file is laid out on disk
+type Layout struct {
+	Size 			int64 // size of the ecc file
+	TrailerVersion 	int // 0 for legacy files without a trailer
+	TrailerSize 	int64 // records and footer
+}
+
+// ReadLayout inspects the end of an ecc file
+func ReadLayout(f io.ReaderAt) (*Layout, error) {
+	l, _, err := findTrailer(f)
+	return l, err
+}
+
+// readTrailer looks for a trailer at the end of the ecc file
+func readTrailer(f io.ReaderAt, meta *types.Metadata) error {
+	meta.LegacyPadding = true
+	l, records, err := findTrailer(f)
+	if err != nil || records == nil {
+		return err
+	}
+	meta.LegacyPadding = l.TrailerVersion < 2
+	return decodeTrailer(records, meta)
+}
+
+func findTrailer(f io.ReaderAt) (*Layout, []byte, error) {
+	l := &Layout{Size: Size(f)}
+	if l.Size < 0 {
+		return nil, nil, ErrUnknownSize
+	}
+	if l.Size < HeaderSize+footerSize {
+		return l, nil, nil
+	}
+
+	var ft footer
+	buf := make([]byte, footerSize)
+	if _, err := f.ReadAt(buf, l.Size-footerSize); err != nil {
+		return nil, nil, err
+	}
+	binary.Read(bytes.NewReader(buf), binary.LittleEndian, &ft)
+	if ft.Magic != trailerMagic {
+		return l, nil, nil // legacy file
+	}
+	if int64(ft.Length) > l.Size-footerSize-HeaderSize {
+		return nil, nil, errBadTrailer
+	}
+
+	records := make([]byte, ft.Length)
+	if _, err := f.ReadAt(records, l.Size-footerSize-int64(ft.Length)); err != nil {
+		return nil, nil, err
+	}
+	l.TrailerVersion = int(ft.Version)
+	l.TrailerSize = int64(ft.Length) + footerSize
+	return l, records, nil
+}
+
+// Fingerprint identifies a set of metadata, e.g. to match reports against ecc files
+func Fingerprint(meta *types.Metadata) string {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, headerOf(meta))
+	buf.Write(encodeTrailer(meta))
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,131 @@
+// Package logging carries log events from library calls to their callers.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Level int
+
+const (
+	Debug Level = iota // per-chunk details, e.g. crc mismatches
+	Info // summaries
+	Warn // interruptions, sections that could not be repaired
+	Error // failures that are also returned as errors
+)
+
+func (l Level) String() string {
+	switch l {
+		case Debug:
+			return "debug"
+		case Info:
+			return "info"
+		case Warn:
+			return "warn"
+		case Error:
+			return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Field is a key and value attached to an event, e.g. the section it is about
+type Field struct {
+	Key 	string
+	Value 	interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+func Section(s int) Field {
+	return Field{"section", s}
+}
+
+func Chunk(c int) Field {
+	return Field{"chunk", c}
+}
+
+func Offset(o int64) Field {
+	return Field{"offset", o}
+}
+
+func Err(err error) Field {
+	return Field{"error", err}
+}
+
+// CRC values are printed in hex
+type CRC uint32
+
+func (c CRC) String() string {
+	return fmt.Sprintf("%08x", uint32(c))
+}
+
+type Event struct {
+	Level 	Level
+	Message string
+	Fields 	[]Field
+}
+
+// String formats the event as the message followed by key=value pairs
+func (e *Event) String() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		switch v := f.Value.(type) {
+			case string:
+				fmt.Fprintf(&b, " %s=%q", f.Key, v)
+			default:
+				fmt.Fprintf(&b, " %s=%v", f.Key, v)
+		}
+	}
+	return b.String()
+}
+
+/**
+ * Func receives log events. Like progress.Func it is called from the
+ * goroutine doing the work. Library calls are silent if it is nil.
+ */
+type Func func(*Event)
+
+// Logger sends events to a Func, dropping them if there is none
+type Logger struct {
+	f Func
+}
+
+func New(f Func) Logger {
+	return Logger{f}
+}
+
+func (l Logger) Log(level Level, msg string, fields ...Field) {
+	if l.f != nil {
+		l.f(&Event{Level: level, Message: msg, Fields: fields})
+	}
+}
+
+func (l Logger) Debug(msg string, fields ...Field) {
+	l.Log(Debug, msg, fields...)
+}
+
+func (l Logger) Info(msg string, fields ...Field) {
+	l.Log(Info, msg, fields...)
+}
+
+func (l Logger) Warn(msg string, fields ...Field) {
+	l.Log(Warn, msg, fields...)
+}
+
+func (l Logger) Error(msg string, fields ...Field) {
+	l.Log(Error, msg, fields...)
+}
+
+// Std returns a Func printing events of level min and above with the log package
+func Std(min Level) Func {
+	return func(e *Event) {
+		if e.Level >= min {
+			log.Println(e)
+		}
+	}
+}
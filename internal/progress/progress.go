@@ -0,0 +1,75 @@
+// Package progress carries progress reports from long-running library calls to their callers.
+package progress
+
+import (
+	"time"
+)
+
+// Stats is a snapshot of a running encode or scan
+type Stats struct {
+	Done 		int64 // data bytes processed so far
+	Total 		int64 // data bytes to process, -1 while unknown
+	Resumed 	int64 // data bytes done before the call started, when resuming
+	IO 			time.Duration // time spent reading and writing files
+	Coding 		time.Duration // time spent on crc and reed-solomon calculations
+	Finished 	bool // last report of a call
+}
+
+/**
+ * Func receives progress reports. It is called after every section from the
+ * goroutine doing the work, and once more with Finished set when it ends,
+ * so it should return quickly.
+ */
+type Func func(Stats)
+
+// Tracker accumulates Stats for a library call
+type Tracker struct {
+	Stats
+	report Func
+	mark time.Time
+}
+
+func NewTracker(report Func, total int64) *Tracker {
+	return &Tracker{Stats: Stats{Total: total}, report: report, mark: time.Now()}
+}
+
+// Resume records that data up to byte done was processed earlier
+func (t *Tracker) Resume(done int64) {
+	if done > t.Total {
+		done = t.Total
+	}
+	t.Resumed = done
+	t.Done = done
+}
+
+// IODone adds the time since the last mark to IO
+func (t *Tracker) IODone() {
+	now := time.Now()
+	t.IO += now.Sub(t.mark)
+	t.mark = now
+}
+
+// CodingDone adds the time since the last mark to Coding
+func (t *Tracker) CodingDone() {
+	now := time.Now()
+	t.Coding += now.Sub(t.mark)
+	t.mark = now
+}
+
+// Advance records that data up to byte done has been processed and reports it
+func (t *Tracker) Advance(done int64) {
+	if t.Total >= 0 && done > t.Total {
+		done = t.Total
+	}
+	t.Done = done
+	if t.report != nil {
+		t.report(t.Stats)
+	}
+}
+
+func (t *Tracker) Finish() {
+	t.Finished = true
+	if t.report != nil {
+		t.report(t.Stats)
+	}
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// Output is the document printed with -format json
+type Output struct {
+	Scan 	*ScanResult 	`json:"scan,omitempty"`
+	Repair 	*RepairResult 	`json:"repair,omitempty"`
+}
+
+type ScanResult struct {
+	DataFile 		string 		`json:"data_file"`
+	Fingerprint 	string 		`json:"fingerprint"`
+	FileSize 		int64 		`json:"file_size"`
+	ActualSize 		int64 		`json:"actual_size"`
+	BlockSize 		int32 		`json:"block_size"`
+	NumData 		uint16 		`json:"num_data"`
+	Clean 			bool 		`json:"clean"`
+	Repairable 		bool 		`json:"repairable"`
+	Sections 		[]Section 	`json:"damaged_sections"`
+}
+
+type Section struct {
+	Section 		int 		`json:"section"`
+	Offset 			int64 		`json:"offset"` // in the data file
+	Length 			int64 		`json:"length"`
+	NumRecovery 	int 		`json:"num_recovery"`
+	Repairable 		bool 		`json:"repairable"`
+	Chunks 			[]Chunk 	`json:"chunks"`
+}
+
+type Chunk struct {
+	Type 			string 		`json:"type"` // data or ecc
+	Index 			int 		`json:"index"` // same numbering as -ddmg and -edmg
+	Offset 			int64 		`json:"offset"` // in the data or ecc file
+	Length 			int64 		`json:"length"`
+	ExpectedCRC 	string 		`json:"expected_crc,omitempty"`
+	ActualCRC 		string 		`json:"actual_crc,omitempty"`
+	Reason 			string 		`json:"reason"` // crc, missing, unreadable or reported
+}
+
+type RepairResult struct {
+	Output 			string 		`json:"output"`
+	Repaired 		[]int 		`json:"repaired_sections"`
+	Failed 			[]int 		`json:"failed_sections"`
+	Success 		bool 		`json:"success"`
+	Verified 		*bool 		`json:"verified,omitempty"` // output scanned clean, if checked
+}
+
+func NewScanResult(dataName string, meta *types.Metadata, damages []decoding.DamageDesc, sizeDiff int64) *ScanResult {
+	res := &ScanResult{
+		DataFile: dataName,
+		Fingerprint: filehelper.Fingerprint(meta),
+		FileSize: meta.FileSize,
+		ActualSize: meta.FileSize + sizeDiff,
+		BlockSize: meta.BlockSize,
+		NumData: meta.NumData,
+		Clean: len(damages) == 0 && sizeDiff == 0,
+		Repairable: true,
+		Sections: make([]Section, 0, len(damages)),
+	}
+
+	bs := int64(meta.BlockSize)
+	for _, d := range damages {
+		nr := meta.RecoveryAt(d.Section)
+		sec := Section{
+			Section: d.Section,
+			Offset: int64(d.Section) * meta.SectionSize(),
+			Length: int64(meta.DataChunksAt(d.Section)) * bs,
+			NumRecovery: nr,
+			Repairable: d.Repairable(meta),
+		}
+		if end := meta.FileSize - sec.Offset; sec.Length > end {
+			sec.Length = end
+		}
+
+		for _, idx := range d.DataDamage {
+			c := chunkOf(d, false, idx)
+			c.Index = d.Section*int(meta.NumData) + idx
+			c.Offset = int64(c.Index) * bs
+			c.Length = bs
+			if end := meta.FileSize - c.Offset; c.Length > end {
+				c.Length = end
+			}
+			sec.Chunks = append(sec.Chunks, c)
+		}
+		for _, idx := range d.EccDamage {
+			c := chunkOf(d, true, idx)
+			c.Index = meta.EccChunkStart(d.Section) + idx
+			c.Offset = filehelper.HeaderSize + int64(c.Index)*bs
+			c.Length = bs
+			sec.Chunks = append(sec.Chunks, c)
+		}
+		res.Repairable = res.Repairable && sec.Repairable
+		res.Sections = append(res.Sections, sec)
+	}
+	return res
+}
+
+// chunkOf fills in what ScanFile found out about a chunk
+func chunkOf(d decoding.DamageDesc, ecc bool, idx int) Chunk {
+	c := Chunk{Type: "data", Reason: "reported"}
+	if ecc {
+		c.Type = "ecc"
+	}
+	for _, detail := range d.Details {
+		if detail.Ecc != ecc || detail.Index != idx {
+			continue
+		}
+		c.Reason = detail.Reason
+		c.ExpectedCRC = fmt.Sprintf("%08x", detail.Expected)
+		if detail.Reason == decoding.DamageCRC {
+			c.ActualCRC = fmt.Sprintf("%08x", detail.Actual)
+		}
+	}
+	return c
+}
+
+// NewRepairResult lists sections with data damage that FastRepair did not repair as failed
+func NewRepairResult(output string, damages []decoding.DamageDesc, repaired []int, success bool) *RepairResult {
+	res := &RepairResult{Output: output, Repaired: repaired, Failed: []int{}, Success: success}
+	if res.Repaired == nil {
+		res.Repaired = []int{}
+	}
+	done := make(map[int]bool)
+	for _, s := range repaired {
+		done[s] = true
+	}
+	for _, d := range damages {
+		if len(d.DataDamage) != 0 && !done[d.Section] {
+			res.Failed = append(res.Failed, d.Section)
+		}
+	}
+	return res
+}
+
+func (o *Output) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(o)
+}
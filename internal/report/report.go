@@ -0,0 +1,130 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"github.com/AlexHalogen/RSFileProtect/internal/cmdparser"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+/*
+Damage reports are plain text so they can be reviewed and edited before a
+manual repair:
+
+  # comment
+  fingerprint: 9b0c...   identifies the ecc file the report belongs to
+  data-file: name        informational
+  file-size: 1048576     informational
+  data: 1,3,10-40        damaged data chunks, see cmdparser.ParseChunkList
+  ecc: 2                 damaged ecc chunks
+  scanned: 1024          only in checkpoints of interrupted scans, sections checked so far
+*/
+
+type Report struct {
+	Fingerprint 	string
+	DataName 		string
+	FileSize 		int64
+	Data 			string // chunk lists as written in the report
+	Ecc 			string
+	Scanned 		int // sections checked by an interrupted scan, 0 for complete reports
+}
+
+func Write(w io.Writer, dataName string, meta *types.Metadata, damages []decoding.DamageDesc) error {
+	return WritePartial(w, dataName, meta, damages, 0)
+}
+
+// WritePartial writes the damages found in the first sections of a scan, see decoding.Options
+func WritePartial(w io.Writer, dataName string, meta *types.Metadata, damages []decoding.DamageDesc, sections int) error {
+	var data, ecc []int
+	nd := int(meta.NumData)
+	for _, d := range damages {
+		for _, v := range d.DataDamage {
+			data = append(data, d.Section*nd+v)
+		}
+		for _, v := range d.EccDamage {
+			ecc = append(ecc, meta.EccChunkStart(d.Section)+v)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "# Damage report, edit the data and ecc lines to change what gets repaired.\n"+
+		"# Chunk lists take indices, ranges (10-40) and hex byte offset ranges (0x1000-0x1fff).\n"+
+		"fingerprint: %s\ndata-file: %s\nfile-size: %d\ndata: %s\necc: %s\n",
+		filehelper.Fingerprint(meta), dataName, meta.FileSize,
+		cmdparser.FormatChunkList(data), cmdparser.FormatChunkList(ecc))
+	if err == nil && sections > 0 {
+		_, err = fmt.Fprintf(w, "scanned: %d\n", sections)
+	}
+	return err
+}
+
+func Read(r io.Reader) (*Report, error) {
+	var rep Report
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		sep := strings.Index(text, ":")
+		if sep < 0 {
+			return nil, fmt.Errorf("report line %d: expected key: value", line)
+		}
+		key, value := strings.TrimSpace(text[:sep]), strings.TrimSpace(text[sep+1:])
+
+		switch key {
+			case "fingerprint":
+				rep.Fingerprint = value
+			case "data-file":
+				rep.DataName = value
+			case "file-size":
+				size, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("report line %d: invalid file size %q", line, value)
+				}
+				rep.FileSize = size
+			case "data":
+				rep.Data = value
+			case "ecc":
+				rep.Ecc = value
+			case "scanned":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("report line %d: invalid number of sections %q", line, value)
+				}
+				rep.Scanned = n
+			default:
+				return nil, fmt.Errorf("report line %d: unknown key %q", line, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if rep.Fingerprint == "" {
+		return nil, fmt.Errorf("report has no fingerprint")
+	}
+	return &rep, nil
+}
+
+// Damages checks the report against the ecc file's metadata and converts it for FastRepair
+func (rep *Report) Damages(meta *types.Metadata) ([]decoding.DamageDesc, error) {
+	if fp := filehelper.Fingerprint(meta); rep.Fingerprint != fp {
+		return nil, fmt.Errorf("report belongs to a different ecc file (fingerprint %s, expected %s)", rep.Fingerprint, fp)
+	}
+	if rep.Scanned > meta.NumSections() {
+		return nil, fmt.Errorf("report covers %d sections, the file has %d", rep.Scanned, meta.NumSections())
+	}
+	data, err := cmdparser.ParseChunkList(rep.Data, meta, false)
+	if err != nil {
+		return nil, err
+	}
+	ecc, err := cmdparser.ParseChunkList(rep.Ecc, meta, true)
+	if err != nil {
+		return nil, err
+	}
+	return cmdparser.CSVToDamage(meta, data, ecc), nil
+}
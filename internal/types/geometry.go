@@ -0,0 +1,167 @@
+package types
+
+import (
+	"sort"
+)
+
+// SectionRun is a range of consecutive sections sharing the same number of ecc chunks
+type SectionRun struct {
+	First 		int // index of the first section in this run
+	Count 		int
+	NumRecovery int
+}
+
+// SectionSize returns the number of data bytes covered by one section
+func (m *Metadata) SectionSize() int64 {
+	return int64(m.NumData) * int64(m.BlockSize)
+}
+
+func (m *Metadata) NumSections() int {
+	ss := m.SectionSize()
+	if ss <= 0 || m.FileSize <= 0 {
+		return 0
+	}
+	return int((m.FileSize + ss - 1) / ss)
+}
+
+// DataChunksAt returns the number of data chunks of a section that hold file contents
+func (m *Metadata) DataChunksAt(section int) int {
+	bs := int64(m.BlockSize)
+	rem := m.FileSize - int64(section)*m.SectionSize()
+	if rem <= 0 {
+		return 0
+	}
+	n := (rem + bs - 1) / bs
+	if n > int64(m.NumData) {
+		return int(m.NumData)
+	}
+	return int(n)
+}
+
+/**
+ * StalePadding reports whether the first padding chunk of a section holds
+ * unknown data. Older encoders padded a short last section with zeros only
+ * after that chunk and left the data of the section before in it, so its
+ * data chunks cannot be rebuilt from the ecc. Files without that issue
+ * record it in their trailer, see LegacyPadding
+ */
+func (m *Metadata) StalePadding(section int) bool {
+	return m.LegacyPadding && section > 0 && section == m.NumSections()-1 && m.DataChunksAt(section) < int(m.NumData)
+}
+
+/**
+ * Number of ecc chunks protecting a section. Sections overlapping one or more
+ * regions use the highest level among them, all others use m.NumRecovery
+ */
+func (m *Metadata) RecoveryAt(section int) int {
+	ss := m.SectionSize()
+	lo := int64(section) * ss
+	hi := lo + ss
+
+	level := -1
+	for _, r := range m.Regions {
+		if r.Start < hi && r.End > lo && int(r.NumRecovery) > level {
+			level = int(r.NumRecovery)
+		}
+	}
+	if level < 0 {
+		return int(m.NumRecovery)
+	}
+	return level
+}
+
+func (m *Metadata) MaxRecovery() int {
+	max := int(m.NumRecovery)
+	for _, r := range m.Regions {
+		if int(r.NumRecovery) > max {
+			max = int(r.NumRecovery)
+		}
+	}
+	return max
+}
+
+// Runs splits all sections of the file into runs with a constant number of ecc chunks
+func (m *Metadata) Runs() []SectionRun {
+	sections := m.NumSections()
+	if sections == 0 {
+		return nil
+	}
+
+	ss := m.SectionSize()
+	bounds := []int{0, sections}
+	for _, r := range m.Regions {
+		if r.End <= r.Start {
+			continue
+		}
+		for _, b := range []int{int(r.Start / ss), int((r.End-1)/ss) + 1} {
+			if b > 0 && b < sections {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	sort.Ints(bounds)
+
+	runs := make([]SectionRun, 0, len(bounds))
+	for i:=1; i<len(bounds); i++ {
+		first, next := bounds[i-1], bounds[i]
+		if first == next {
+			continue
+		}
+		nr := m.RecoveryAt(first)
+		if l := len(runs); l > 0 && runs[l-1].NumRecovery == nr {
+			runs[l-1].Count += next - first
+		} else {
+			runs = append(runs, SectionRun{First: first, Count: next - first, NumRecovery: nr})
+		}
+	}
+	return runs
+}
+
+// EccChunkStart returns the global index of the first ecc chunk of a section
+func (m *Metadata) EccChunkStart(section int) int {
+	idx := 0
+	end := 0
+	for _, r := range m.Runs() {
+		if section < r.First+r.Count {
+			return idx + (section-r.First)*r.NumRecovery
+		}
+		idx += r.Count * r.NumRecovery
+		end = r.First + r.Count
+	}
+	// past the end of file, assume default level
+	return idx + (section-end)*int(m.NumRecovery)
+}
+
+// EccChunks returns the number of ecc chunks of the whole file
+func (m *Metadata) EccChunks() int {
+	return m.EccChunkStart(m.NumSections())
+}
+
+// EccChunkSection maps a global ecc chunk index to its section and position within that section
+func (m *Metadata) EccChunkSection(idx int) (section int, offset int) {
+	end := 0
+	for _, r := range m.Runs() {
+		size := r.Count * r.NumRecovery
+		if idx < size {
+			return r.First + idx/r.NumRecovery, idx % r.NumRecovery
+		}
+		idx -= size
+		end = r.First + r.Count
+	}
+	nr := int(m.NumRecovery)
+	return end + idx/nr, idx % nr
+}
+
+// SameGeometry reports whether both describe the same layout of chunks and sections
+func (m *Metadata) SameGeometry(o *Metadata) bool {
+	if m.FileSize != o.FileSize || m.BlockSize != o.BlockSize || m.NumData != o.NumData ||
+		m.NumRecovery != o.NumRecovery || len(m.Regions) != len(o.Regions) {
+		return false
+	}
+	for i := range m.Regions {
+		if m.Regions[i] != o.Regions[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -6,4 +6,17 @@ type Metadata struct {
 	NumData 		uint16 // number of data chunks in one iteration
 	NumRecovery 	uint16 // number of ecc chunks in one iteration
 	Ecc				[16]byte // ecc code for above data
+	Regions			[]Region // optional byte ranges with their own number of ecc chunks
+	LegacyPadding 	bool // written by an encoder that left stale data in the padding of the last section
+	Created			int64 // unix time the ecc file was written, 0 if not recorded
+	Creator			string // program that wrote the ecc file
+	DataName		string // base name of the protected file at encoding time
+	Digest			[32]byte // sha256 of the data, recorded by stream encodings; zero if not recorded
+}
+
+// Region overrides NumRecovery for every section overlapping [Start, End)
+type Region struct {
+	Start 			int64
+	End 			int64 // exclusive
+	NumRecovery 	uint16
 }
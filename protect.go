@@ -0,0 +1,209 @@
+package rsfileprotect
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+const (
+	DefaultBlockSize = 4096
+	DefaultLevel = 1
+	MaxLevel = 10
+	numData = 10 // data chunks per section
+)
+
+type ProtectOptions struct {
+	EccPath 		string // <path>.ecc if empty
+	CRCPath 		string // <ecc>.crc if empty
+	CheckpointPath 	string // <ecc>.partial if empty
+	BlockSize 		int // DefaultBlockSize if zero
+	Level 			int // ecc chunks per 10 data chunks, DefaultLevel if zero
+	Regions 		[]Region // byte ranges with a level of their own
+	Creator 		string // recorded in the ecc file, "rsfileprotect" if empty
+	DataName 		string // recorded in the ecc file, the base name of path if empty
+	Resume 			bool // continue from the checkpoint of an interrupted call on the same file
+	Progress 		func(Progress) // called after every section, off if nil
+	Log 			func(LogEvent) // receives messages about the call, silent if nil
+}
+
+// EccPathFor returns the default ecc file of a data file
+func EccPathFor(path string) string {
+	return path + ".ecc"
+}
+
+// CRCPathFor returns the default crc file of an ecc file
+func CRCPathFor(ecc string) string {
+	return ecc + ".crc"
+}
+
+// CheckpointPathFor returns where Protect keeps its progress while encoding into ecc
+func CheckpointPathFor(ecc string) string {
+	return ecc + ".partial"
+}
+
+func (o *ProtectOptions) check(path string) error {
+	if o.EccPath == "" {
+		o.EccPath = EccPathFor(path)
+	}
+	if o.CRCPath == "" {
+		o.CRCPath = CRCPathFor(o.EccPath)
+	}
+	if o.CheckpointPath == "" {
+		o.CheckpointPath = CheckpointPathFor(o.EccPath)
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+	if o.Level == 0 {
+		o.Level = DefaultLevel
+	}
+	if o.Creator == "" {
+		o.Creator = "rsfileprotect"
+	}
+	if o.BlockSize < 0 {
+		return invalidf("block size %d is not positive", o.BlockSize)
+	}
+	if o.Level < 1 || o.Level > MaxLevel {
+		return invalidf("level %d is not within 1 to %d", o.Level, MaxLevel)
+	}
+	for _, r := range o.Regions {
+		if r.Start < 0 || r.End <= r.Start {
+			return invalidf("region [%d, %d) is empty", r.Start, r.End)
+		}
+		if r.Level < 1 || r.Level > MaxLevel {
+			return invalidf("level %d of region [%d, %d) is not within 1 to %d", r.Level, r.Start, r.End, MaxLevel)
+		}
+	}
+	return nil
+}
+
+/**
+ * Protect writes the ecc and crc files of the file at path. The ecc file is
+ * only marked complete once everything is written; until then progress is
+ * kept in a checkpoint file. If ctx is canceled the partial files are kept
+ * for a later call with Resume set, and ctx.Err() is returned. On other
+ * errors they are removed.
+ */
+func Protect(ctx context.Context, path string, opts ProtectOptions) error {
+	if err := opts.check(path); err != nil {
+		return err
+	}
+
+	dataFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	fs, err := dataFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	if opts.DataName == "" {
+		opts.DataName = filepath.Base(path)
+	}
+	meta := opts.meta(fs.Size())
+
+	logger := logging.New(logFunc(opts.Log))
+	modTime := fs.ModTime().UnixNano()
+	resumeAt := 0
+	if opts.Resume {
+		if ckpt := loadCheckpoint(logger, opts.CheckpointPath, &meta, modTime); ckpt != nil {
+			meta = ckpt.Meta // keeps the creation details of the first run
+			resumeAt = ckpt.Sections
+		}
+	}
+
+	mode := os.O_TRUNC|os.O_CREATE|os.O_WRONLY
+	if resumeAt > 0 {
+		mode = os.O_RDWR
+	}
+	eccFile, err := os.OpenFile(opts.EccPath, mode, 0644)
+	if err != nil {
+		return err
+	}
+	defer eccFile.Close()
+	crcFile, err := os.OpenFile(opts.CRCPath, mode, 0644)
+	if err != nil {
+		return err
+	}
+	defer crcFile.Close()
+
+	eopts := &encoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log)}
+	eopts.Checkpoint = func(sections int) error {
+		return filehelper.WriteCheckpoint(opts.CheckpointPath, &filehelper.Checkpoint{Meta: meta, Sections: sections, ModTime: modTime})
+	}
+	if resumeAt > 0 {
+		logger.Info("Resuming", logging.Section(resumeAt), logging.F("sections", meta.NumSections()))
+		err = encoding.ResumeWith(ctx, eopts, meta, dataFile, eccFile, crcFile, resumeAt)
+	} else {
+		err = encoding.EncodeWith(ctx, eopts, meta, dataFile, eccFile, crcFile)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			if _, serr := os.Stat(opts.CheckpointPath); serr == nil {
+				return err
+			}
+		}
+		eccFile.Close()
+		crcFile.Close()
+		for _, name := range []string{opts.EccPath, opts.CRCPath, opts.CheckpointPath} {
+			os.Remove(name)
+		}
+		return err
+	}
+	if err := os.Remove(opts.CheckpointPath); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Checkpoint not removed", logging.Err(err))
+	}
+	return nil
+}
+
+/**
+ * ProtectReaderAt protects the first size bytes of data, writing the ecc and
+ * crc chunks to ecc and crc from offset 0. Unlike Protect it keeps no
+ * checkpoint, and the paths and Resume in opts are not used. If ctx is
+ * canceled ctx.Err() is returned and ecc is left marked incomplete.
+ */
+func ProtectReaderAt(ctx context.Context, data io.ReaderAt, size int64, ecc, crc io.WriterAt, opts ProtectOptions) error {
+	if err := opts.check(""); err != nil {
+		return err
+	}
+	eo := &encoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log)}
+	return encoding.EncodeWith(ctx, eo, opts.meta(size), data, ecc, crc)
+}
+
+func (o *ProtectOptions) meta(size int64) types.Metadata {
+	meta := types.Metadata{FileSize: size, BlockSize: int32(o.BlockSize), NumData: numData, NumRecovery: uint16(o.Level)}
+	meta.Created = time.Now().Unix()
+	meta.Creator = o.Creator
+	meta.DataName = o.DataName
+	meta.Regions = toRegions(o.Regions)
+	return meta
+}
+
+/**
+ * Returns the checkpoint of an earlier call with the same data file and
+ * options, nil if there is none to resume from
+ */
+func loadCheckpoint(logger logging.Logger, name string, meta *types.Metadata, modTime int64) *filehelper.Checkpoint {
+	ckpt, err := filehelper.ReadCheckpoint(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Warn("Checkpoint not readable, starting over", logging.F("file", name), logging.Err(err))
+		return nil
+	}
+	if ckpt.ModTime != modTime || !ckpt.Meta.SameGeometry(meta) {
+		logger.Info("Checkpoint belongs to a different file or different options, starting over", logging.F("file", name))
+		return nil
+	}
+	return ckpt
+}
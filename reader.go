@@ -0,0 +1,172 @@
+package rsfileprotect
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+)
+
+type ReaderOptions struct {
+	EccPath 	string // <path>.ecc if empty
+	CRCPath 	string // <ecc>.crc if empty
+	Salvage 	*SalvageOptions // off if nil
+	Cache 		int // sections kept in memory, 4 if zero and none if negative
+	Repaired 	func(Damage) // called once for every section rebuilt on read, from the goroutine reading it
+	Log 		func(LogEvent) // receives damaged chunks and sections repaired on read, silent if nil
+}
+
+/**
+ * Reader reads a protected file through its ecc and crc files. Every section
+ * read is checked with the crc file, and damaged ones are rebuilt from the
+ * ecc file in memory, so that the bytes returned are those that were
+ * protected. The files themselves are left as they are, see Repair. A
+ * Reader may be used from several goroutines at once.
+ */
+type Reader struct {
+	healer 		*decoding.Healer
+	opts 		ReaderOptions
+	logger 		logging.Logger
+	close 		func()
+	mu 			sync.Mutex // guards the fields below
+	cache 		map[int][]byte
+	cached 		[]int // sections in cache, oldest first
+	repaired 	[]Damage
+	seen 		map[int]bool // sections in repaired
+}
+
+// Open returns a Reader for the file at path, which has to be closed after use
+func Open(path string, opts ReaderOptions) (*Reader, error) {
+	sidecars(path, &opts.EccPath, &opts.CRCPath)
+	fs, closeAll, err := openFiles(path, opts.EccPath, opts.CRCPath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := fs.reader(opts)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	r.close = closeAll
+	return r, nil
+}
+
+/**
+ * NewReader is Open for data that is not in a file of its own, see
+ * ScanReaderAt. The paths in opts are not used, and Close does not close
+ * the readers.
+ */
+func NewReader(data, ecc, crc io.ReaderAt, opts ReaderOptions) (*Reader, error) {
+	fs, err := newFileSet("ecc file", data, ecc, crc)
+	if err != nil {
+		return nil, err
+	}
+	return fs.reader(opts)
+}
+
+func (fs *fileSet) reader(opts ReaderOptions) (*Reader, error) {
+	so, _ := salvageFor(opts.Salvage, nil)
+	do := &decoding.Options{Log: logFunc(opts.Log), Salvage: so}
+	healer, err := decoding.NewHealer(do, &fs.meta, fs.data, fs.ecc, fs.crc)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cache == 0 {
+		opts.Cache = 4
+	}
+	return &Reader{healer: healer, opts: opts, logger: logging.New(do.Log),
+		cache: make(map[int][]byte), seen: make(map[int]bool)}, nil
+}
+
+// Size returns the size of the protected file
+func (r *Reader) Size() int64 {
+	return r.healer.Meta().FileSize
+}
+
+/**
+ * ReadAt implements io.ReaderAt. If a section is damaged beyond repair, the
+ * bytes before it are returned along with an *UnrecoverableError.
+ */
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	meta := r.healer.Meta()
+	if off < 0 {
+		return 0, invalidf("negative offset %d", off)
+	}
+	if off >= meta.FileSize {
+		return 0, io.EOF
+	}
+	sectionSize := meta.SectionSize()
+	n := 0
+	for n < len(p) && off < meta.FileSize {
+		section := int(off / sectionSize)
+		data, err := r.section(section)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-int64(section)*sectionSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *Reader) section(i int) ([]byte, error) {
+	r.mu.Lock()
+	data, ok := r.cache[i]
+	r.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, damage, err := r.healer.Section(i)
+	if errors.Is(err, decoding.ErrUnrecoverableSection) || errors.Is(err, decoding.ErrStalePadding) {
+		return nil, &UnrecoverableError{[]int{i}}
+	} else if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	first := damage != nil && !r.seen[i]
+	var d Damage
+	if first {
+		d = fromDamages(r.healer.Meta(), []decoding.DamageDesc{*damage})[0]
+		r.seen[i] = true
+		r.repaired = append(r.repaired, d)
+	}
+	if _, ok := r.cache[i]; !ok && r.opts.Cache > 0 {
+		if len(r.cached) == r.opts.Cache {
+			delete(r.cache, r.cached[0])
+			r.cached = r.cached[1:]
+		}
+		r.cache[i] = data
+		r.cached = append(r.cached, i)
+	}
+	r.mu.Unlock()
+
+	if first {
+		r.logger.Info("Section repaired on read", logging.Section(i), logging.F("data", len(d.Data)), logging.F("ecc", len(d.Ecc)))
+		if r.opts.Repaired != nil {
+			r.opts.Repaired(d)
+		}
+	}
+	return data, nil
+}
+
+// Repaired returns the sections rebuilt on read so far, in the order they were read
+func (r *Reader) Repaired() []Damage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Damage{}, r.repaired...)
+}
+
+// Close closes the files opened by Open
+func (r *Reader) Close() error {
+	if r.close != nil {
+		r.close()
+		r.close = nil
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Package rsfileprotect protects files against bit rot with Reed-Solomon codes
+kept in two sidecar files next to the data: FILE.ecc holds the parity chunks
+and FILE.ecc.crc a crc32 for every data and parity chunk.
+
+	err := rsfileprotect.Protect(ctx, "archive.tar", rsfileprotect.ProtectOptions{Level: 2})
+	...
+	res, err := rsfileprotect.Scan(ctx, "archive.tar", rsfileprotect.ScanOptions{})
+	if !res.Clean() {
+		_, err = rsfileprotect.Repair(ctx, "archive.tar", "archive.fixed", rsfileprotect.RepairOptions{Verify: true})
+	}
+
+Files are split into chunks of BlockSize bytes, and every 10 data chunks form a
+section that is protected by Level parity chunks. A section can be rebuilt as
+long as no more than Level of its data and parity chunks are damaged.
+
+Data that does not live in a file of its own, e.g. in memory or inside an
+archive, is handled by the ReaderAt variants of the calls.
+*/
+package rsfileprotect
+
+import (
+	"sort"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// Version of the API, following semantic versioning
+const Version = "1.0.0"
+
+// Region is a byte range [Start, End) protected with its own Level
+type Region struct {
+	Start 	int64
+	End 	int64
+	Level 	int
+}
+
+// Progress is a snapshot of a running call, see ProtectOptions.Progress
+type Progress struct {
+	Done 		int64 // data bytes processed so far
+	Total 		int64 // -1 while unknown, when protecting a stream
+	Resumed 	int64 // data bytes done before the call started, when resuming
+	IO 			time.Duration // time spent reading and writing files
+	Coding 		time.Duration // time spent on crc and reed-solomon calculations
+	Finished 	bool // last report of a call
+}
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota // damaged chunks
+	LogInfo // summaries
+	LogWarn // interruptions, sections that cannot be rebuilt
+	LogError // failures that are returned as errors as well
+)
+
+func (l LogLevel) String() string {
+	return logging.Level(l).String()
+}
+
+// LogEvent is a message from a running call, see ProtectOptions.Log
+type LogEvent struct {
+	Level 	LogLevel
+	Message string
+	Fields 	[]LogField // what the message is about, e.g. section, chunk and offset
+}
+
+type LogField struct {
+	Key 	string
+	Value 	interface{}
+}
+
+// String formats the event as the message followed by key=value pairs
+func (e LogEvent) String() string {
+	ev := logging.Event{Level: logging.Level(e.Level), Message: e.Message}
+	for _, f := range e.Fields {
+		ev.Fields = append(ev.Fields, logging.Field(f))
+	}
+	return ev.String()
+}
+
+// SalvageOptions enables retries and sector-sized reads of data on failing media
+type SalvageOptions struct {
+	Retries 	int // per failed read, 3 if zero and none if negative
+	SectorSize 	int // size of the reads after a chunk keeps failing, 512 if zero
+}
+
+// ByteRange is the range [Start, End) of a file
+type ByteRange struct {
+	Start 	int64
+	End 	int64
+}
+
+// Damage lists the damaged chunks of a section
+type Damage struct {
+	Section 	int
+	Data 		[]int // positions of damaged data chunks within the section
+	Ecc 		[]int // positions of damaged ecc chunks within the section
+	Chunks 		[]ChunkDamage // details, only known from scans
+	Repairable 	bool // few enough chunks are damaged, only set by scans
+}
+
+type ChunkDamage struct {
+	Ecc 		bool
+	Index 		int // position within the section
+	Expected 	uint32 // crc recorded when the file was protected
+	Actual 		uint32 // crc of the chunk as read, 0 if missing or unreadable
+	Reason 		string // crc, missing or unreadable
+}
+
+func progressFunc(f func(Progress)) progress.Func {
+	if f == nil {
+		return nil
+	}
+	return func(s progress.Stats) {
+		f(Progress(s))
+	}
+}
+
+func logFunc(f func(LogEvent)) logging.Func {
+	if f == nil {
+		return nil
+	}
+	return func(e *logging.Event) {
+		ev := LogEvent{Level: LogLevel(e.Level), Message: e.Message}
+		for _, f := range e.Fields {
+			ev.Fields = append(ev.Fields, LogField(f))
+		}
+		f(ev)
+	}
+}
+
+func toRegions(regions []Region) []types.Region {
+	var res []types.Region
+	for _, r := range regions {
+		res = append(res, types.Region{Start: r.Start, End: r.End, NumRecovery: uint16(r.Level)})
+	}
+	return res
+}
+
+func fromRegions(regions []types.Region) []Region {
+	var res []Region
+	for _, r := range regions {
+		res = append(res, Region{Start: r.Start, End: r.End, Level: int(r.NumRecovery)})
+	}
+	return res
+}
+
+func fromDamages(meta *types.Metadata, damages []decoding.DamageDesc) []Damage {
+	res := make([]Damage, 0, len(damages))
+	for _, d := range damages {
+		pd := Damage{Section: d.Section, Data: d.DataDamage, Ecc: d.EccDamage,
+			Repairable: d.Repairable(meta)}
+		for _, c := range d.Details {
+			pd.Chunks = append(pd.Chunks, ChunkDamage{c.Ecc, c.Index, c.Expected, c.Actual, c.Reason})
+		}
+		res = append(res, pd)
+	}
+	return res
+}
+
+// toDamages checks damages given by callers against the geometry
+func toDamages(meta *types.Metadata, damages []Damage) ([]decoding.DamageDesc, error) {
+	res := make([]decoding.DamageDesc, 0, len(damages))
+	for _, d := range damages {
+		if d.Section < 0 || d.Section >= meta.NumSections() {
+			return nil, invalidf("section %d out of range, the file has %d", d.Section, meta.NumSections())
+		}
+		for _, v := range d.Data {
+			if v < 0 || v >= meta.DataChunksAt(d.Section) {
+				return nil, invalidf("data chunk %d of section %d out of range", v, d.Section)
+			}
+		}
+		for _, v := range d.Ecc {
+			if v < 0 || v >= meta.RecoveryAt(d.Section) {
+				return nil, invalidf("ecc chunk %d of section %d out of range", v, d.Section)
+			}
+		}
+		// merging one section at a time keeps res sorted by section, whatever order damages are in
+		res = decoding.MergeDamages(res, []decoding.DamageDesc{{Section: d.Section, DataDamage: sortedUnique(d.Data), EccDamage: sortedUnique(d.Ecc)}})
+	}
+	return res, nil
+}
+
+// sortedUnique returns a sorted copy of idx without duplicates, as MergeDamages expects
+func sortedUnique(idx []int) []int {
+	sorted := append([]int{}, idx...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, v := range sorted {
+		if i == 0 || v != sorted[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
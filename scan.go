@@ -0,0 +1,531 @@
+package rsfileprotect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+/**
+ * ScanOptions are the options of the scans. Checkpoint is called with the
+ * number of sections checked and the damages found in them from
+ * FirstSection on, every CheckpointInterval sections and once more if the
+ * scan is interrupted; a later scan with FirstSection set to that number
+ * picks up from there. Damages from KnownBad are not included. Only Scan
+ * and ScanReaderAt use FirstSection and Checkpoint.
+ */
+type ScanOptions struct {
+	EccPath 			string // <path>.ecc if empty
+	CRCPath 			string // <ecc>.crc if empty
+	Salvage 			*SalvageOptions // off if nil
+	KnownBad 			[]ByteRange // ranges known to be unreadable, e.g. from a ddrescue map
+	FirstSection 		int // sections before it are not read, e.g. those of an interrupted scan; KnownBad still counts for them
+	Checkpoint 			func(sections int, damages []Damage) // off if nil
+	CheckpointInterval 	int // sections between checkpoints, 1024 if zero
+	Progress 			func(Progress) // called after every section, off if nil
+	Log 				func(LogEvent) // receives damaged chunks and summaries, silent if nil
+}
+
+type ScanResult struct {
+	Damages 	[]Damage // sorted by section
+	SizeDiff 	int64 // negative for missing bytes, positive for extra bytes
+	Unreadable 	[]ByteRange // byte ranges that could not be read, with Salvage or KnownBad
+}
+
+// Clean reports whether the file matches its ecc file
+func (r *ScanResult) Clean() bool {
+	return len(r.Damages) == 0 && r.SizeDiff == 0
+}
+
+// Repairable reports whether every damaged section can be rebuilt
+func (r *ScanResult) Repairable() bool {
+	for _, d := range r.Damages {
+		if !d.Repairable {
+			return false
+		}
+	}
+	return true
+}
+
+type RepairOptions struct {
+	EccPath 	string // <path>.ecc if empty
+	CRCPath 	string // <ecc>.crc if empty
+	Damages 	[]Damage // repaired as given instead of scanning the file first
+	Salvage 	*SalvageOptions // off if nil
+	KnownBad 	[]ByteRange
+	Verify 		bool // scan the repaired file afterwards
+	Progress 	func(Progress) // called after every section, off if nil
+	Log 		func(LogEvent) // receives damaged chunks and summaries, silent if nil
+}
+
+type SectionStatus int
+
+const (
+	SectionOK SectionStatus = iota
+	SectionDamaged
+	SectionFailed // the section could not be checked, see Err
+)
+
+// SectionEvent is what ScanEach found in a section
+type SectionEvent struct {
+	Section 	int
+	Status 		SectionStatus
+	Damage 		*Damage // set if Status is SectionDamaged
+	Err 		error // set if Status is SectionFailed, the scan ends with it
+}
+
+// SkipRest ends ScanEach early without an error when returned by its callback
+var SkipRest = decoding.SkipRest
+
+type RepairResult struct {
+	Scan 		*ScanResult // nil if Damages were given
+	Written 	bool // false if there was nothing to repair and out was not created
+	Repaired 	[]int // sections rebuilt from ecc chunks
+	Verified 	bool // the repaired file matches its ecc file, only set with Verify
+}
+
+// fileSet holds the files of a scan or repair, with the data file nil if it does not exist
+type fileSet struct {
+	data, ecc, crc io.ReaderAt
+	meta types.Metadata
+}
+
+func newFileSet(name string, data, ecc, crc io.ReaderAt) (*fileSet, error) {
+	fs := &fileSet{data: data, ecc: ecc, crc: crc}
+	if err := filehelper.ReadMeta(ecc, &fs.meta); err != nil {
+		return nil, badHeader(name, err)
+	}
+	return fs, nil
+}
+
+// openFiles opens the files of a scan or repair, the returned function closes them
+func openFiles(path, ecc, crc string) (*fileSet, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	var data io.ReaderAt
+	f, err := os.Open(path)
+	if err == nil {
+		files = append(files, f)
+		data = f
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	} // a missing data file is entirely damaged
+	for _, name := range []string{ecc, crc} {
+		f, err := os.Open(name)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	fs, err := newFileSet(ecc, data, files[len(files)-2], files[len(files)-1])
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return fs, closeAll, nil
+}
+
+func sidecars(path string, ecc, crc *string) {
+	if *ecc == "" {
+		*ecc = EccPathFor(path)
+	}
+	if *crc == "" {
+		*crc = CRCPathFor(*ecc)
+	}
+}
+
+func salvageFor(opts *SalvageOptions, knownBad []ByteRange) (*filehelper.SalvageOptions, *filehelper.BadMap) {
+	if opts == nil && len(knownBad) == 0 {
+		return nil, nil
+	}
+	bad := &filehelper.BadMap{}
+	for _, r := range knownBad {
+		bad.Add(r.Start, r.End)
+	}
+	so := &filehelper.SalvageOptions{Retries: 3, SectorSize: 512, Map: bad}
+	if opts == nil {
+		so.Retries = 0
+	} else {
+		if opts.Retries < 0 {
+			so.Retries = 0
+		} else if opts.Retries != 0 {
+			so.Retries = opts.Retries
+		}
+		if opts.SectorSize != 0 {
+			so.SectorSize = opts.SectorSize
+		}
+	}
+	return so, bad
+}
+
+func (fs *fileSet) scan(ctx context.Context, opts *ScanOptions) (*ScanResult, []decoding.DamageDesc, error) {
+	if opts.FirstSection < 0 || opts.FirstSection > fs.meta.NumSections() {
+		return nil, nil, invalidf("first section %d out of range, the file has %d", opts.FirstSection, fs.meta.NumSections())
+	}
+	so, bad := salvageFor(opts.Salvage, opts.KnownBad)
+	do := &decoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log), Salvage: so, CheckpointInterval: opts.CheckpointInterval}
+	if opts.Checkpoint != nil {
+		do.Checkpoint = func(sections int, damages []decoding.DamageDesc) {
+			opts.Checkpoint(sections, fromDamages(&fs.meta, damages))
+		}
+	}
+
+	scan, err := decoding.ScanWith(ctx, do, &fs.meta, fs.data, fs.ecc, fs.crc, opts.FirstSection)
+	if err != nil {
+		return nil, nil, err
+	}
+	damages := scan.Damages
+	if len(opts.KnownBad) != 0 {
+		// known bad ranges are erasures even if their contents happen to match
+		damages = decoding.MergeDamages(damages, decoding.DamageFromMap(&fs.meta, bad))
+	}
+	res := &ScanResult{Damages: fromDamages(&fs.meta, damages), SizeDiff: scan.SizeDiff}
+	if bad != nil {
+		for _, r := range bad.Ranges {
+			res.Unreadable = append(res.Unreadable, ByteRange{r.Start, r.End})
+		}
+	}
+	return res, damages, nil
+}
+
+/**
+ * Scan checks the file at path against its ecc and crc files. A missing data
+ * file is reported as entirely damaged rather than as an error.
+ */
+func Scan(ctx context.Context, path string, opts ScanOptions) (*ScanResult, error) {
+	sidecars(path, &opts.EccPath, &opts.CRCPath)
+	fs, closeAll, err := openFiles(path, opts.EccPath, opts.CRCPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll()
+	res, _, err := fs.scan(ctx, &opts)
+	return res, err
+}
+
+/**
+ * ScanReaderAt is Scan for data that is not in a file of its own, e.g. in
+ * memory or inside an archive. data is nil if missing. Its size is taken
+ * from a Size or Stat method, see io.SectionReader. The paths in opts are
+ * not used. The readers are only accessed with ReadAt, so several scans may
+ * share them.
+ */
+func ScanReaderAt(ctx context.Context, data, ecc, crc io.ReaderAt, opts ScanOptions) (*ScanResult, error) {
+	fs, err := newFileSet("ecc file", data, ecc, crc)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := fs.scan(ctx, &opts)
+	return res, err
+}
+
+/**
+ * ScanStream is Scan for data that can only be read once in order, e.g. the
+ * output of tar on a pipe, checked against existing sidecars. EccPath in
+ * opts is required. data is read to its end to tell SizeDiff; failing to
+ * read it is an error rather than damage. Salvage, KnownBad, FirstSection
+ * and Checkpoint are not supported.
+ */
+func ScanStream(ctx context.Context, data io.Reader, opts ScanOptions) (*ScanResult, error) {
+	if opts.EccPath == "" {
+		return nil, invalidf("EccPath is required to scan a stream")
+	}
+	if opts.Salvage != nil || len(opts.KnownBad) != 0 {
+		return nil, invalidf("a stream cannot be salvaged")
+	}
+	if opts.FirstSection != 0 || opts.Checkpoint != nil {
+		return nil, invalidf("a stream is scanned from its start")
+	}
+	sidecars("", &opts.EccPath, &opts.CRCPath)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range []string{opts.EccPath, opts.CRCPath} {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	fs, err := newFileSet(opts.EccPath, nil, files[0], files[1])
+	if err != nil {
+		return nil, err
+	}
+	do := &decoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log)}
+	scan, err := decoding.ScanStream(ctx, do, &fs.meta, data, fs.ecc, fs.crc)
+	if err != nil {
+		return nil, err
+	}
+	return &ScanResult{Damages: fromDamages(&fs.meta, scan.Damages), SizeDiff: scan.SizeDiff}, nil
+}
+
+/**
+ * ScanEach scans like Scan, but passes every section to fn as soon as it is
+ * checked instead of collecting the damages, so that callers can react to
+ * them right away or stop early. If fn returns SkipRest the scan ends and
+ * ScanEach returns nil; other errors from fn end it and are returned.
+ */
+func ScanEach(ctx context.Context, path string, opts ScanOptions, fn func(SectionEvent) error) error {
+	sidecars(path, &opts.EccPath, &opts.CRCPath)
+	fs, closeAll, err := openFiles(path, opts.EccPath, opts.CRCPath)
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+	return fs.each(ctx, &opts, fn)
+}
+
+// ScanEachReaderAt is ScanEach for data that is not in a file of its own, see ScanReaderAt
+func ScanEachReaderAt(ctx context.Context, data, ecc, crc io.ReaderAt, opts ScanOptions, fn func(SectionEvent) error) error {
+	fs, err := newFileSet("ecc file", data, ecc, crc)
+	if err != nil {
+		return err
+	}
+	return fs.each(ctx, &opts, fn)
+}
+
+func (fs *fileSet) each(ctx context.Context, opts *ScanOptions, fn func(SectionEvent) error) error {
+	so, bad := salvageFor(opts.Salvage, opts.KnownBad)
+	do := &decoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log), Salvage: so}
+	return fs.walk(ctx, do, bad, func(ev *decoding.SectionEvent) error {
+		pe := SectionEvent{Section: ev.Section, Status: SectionStatus(ev.Status), Err: ev.Err}
+		if ev.Damage != nil {
+			pe.Damage = &fromDamages(&fs.meta, []decoding.DamageDesc{*ev.Damage})[0]
+		}
+		return fn(pe)
+	})
+}
+
+// walk is decoding.Walk with the ranges in bad added to the damages
+func (fs *fileSet) walk(ctx context.Context, do *decoding.Options, bad *filehelper.BadMap, fn func(*decoding.SectionEvent) error) error {
+	var known []decoding.DamageDesc
+	if bad != nil {
+		known = decoding.DamageFromMap(&fs.meta, bad)
+	}
+	return decoding.Walk(ctx, do, &fs.meta, fs.data, fs.ecc, fs.crc, 0, func(ev *decoding.SectionEvent) error {
+		for len(known) != 0 && known[0].Section < ev.Section {
+			known = known[1:]
+		}
+		if len(known) != 0 && known[0].Section == ev.Section && ev.Status != decoding.SectionFailed {
+			// known bad ranges are erasures even if their contents happen to match
+			d := decoding.DamageDesc{Section: ev.Section}
+			if ev.Damage != nil {
+				d = *ev.Damage
+			}
+			ev.Damage = &decoding.MergeDamages([]decoding.DamageDesc{d}, known[:1])[0]
+			ev.Status = decoding.SectionDamaged
+		}
+		return fn(ev)
+	})
+}
+
+/**
+ * Repair rebuilds the file at path into out, repairing sections as the scan
+ * finds them unless opts.Damages lists what to repair. out is only written if
+ * something is damaged. Sections that cannot be rebuilt are reported by an
+ * *UnrecoverableError, with the rest of out written as well as possible;
+ * the result is returned along with it. If ctx is canceled out is removed.
+ */
+func Repair(ctx context.Context, path string, out string, opts RepairOptions) (*RepairResult, error) {
+	sidecars(path, &opts.EccPath, &opts.CRCPath)
+	fs, closeAll, err := openFiles(path, opts.EccPath, opts.CRCPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll()
+
+	var outFile *os.File
+	res, err := fs.repair(ctx, &opts, func() (io.Writer, error) {
+		f, err := os.OpenFile(out, os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err // not f, a nil *os.File would be a non-nil io.Writer
+		}
+		outFile = f
+		return f, nil
+	})
+	if outFile != nil {
+		if cerr := outFile.Close(); cerr != nil && err == nil {
+			return nil, cerr
+		}
+		if ctx.Err() != nil {
+			os.Remove(out)
+		}
+	}
+	return res, err
+}
+
+/**
+ * RepairReaderAt is Repair for data that is not in a file of its own, see
+ * ScanReaderAt. The repaired data is written to out, which has to be an
+ * io.ReaderAt as well for opts.Verify.
+ */
+func RepairReaderAt(ctx context.Context, data, ecc, crc io.ReaderAt, out io.Writer, opts RepairOptions) (*RepairResult, error) {
+	if _, ok := out.(io.ReaderAt); opts.Verify && !ok {
+		return nil, invalidf("verifying needs an output that is an io.ReaderAt")
+	}
+	fs, err := newFileSet("ecc file", data, ecc, crc)
+	if err != nil {
+		return nil, err
+	}
+	return fs.repair(ctx, &opts, func() (io.Writer, error) {
+		return out, nil
+	})
+}
+
+// repair gets its output from create once it is clear that there is something to repair
+func (fs *fileSet) repair(ctx context.Context, opts *RepairOptions, create func() (io.Writer, error)) (*RepairResult, error) {
+	res := &RepairResult{}
+	var out io.Writer
+	var repaired *decoding.RepairResult
+	var err error
+	if opts.Damages == nil {
+		res.Scan, out, repaired, err = fs.scanAndRepair(ctx, opts, create)
+	} else {
+		out, repaired, err = fs.repairDamages(ctx, opts, create)
+	}
+	if out == nil {
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	if repaired == nil {
+		// the output was created but repairing into it never started
+		return nil, err
+	}
+	res.Written = true
+	res.Repaired = repaired.Repaired
+	if len(repaired.Failed) != 0 && (errors.Is(err, decoding.ErrUnrecoverableSection) || errors.Is(err, decoding.ErrStalePadding)) {
+		return res, &UnrecoverableError{repaired.Failed}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return res, err
+	}
+
+	if opts.Verify {
+		check := &fileSet{data: out.(io.ReaderAt), ecc: fs.ecc, crc: fs.crc, meta: fs.meta}
+		scan, _, err := check.scan(ctx, &ScanOptions{Progress: opts.Progress, Log: opts.Log})
+		if err != nil {
+			return res, err
+		}
+		res.Verified = scan.Clean()
+		if !res.Verified {
+			return res, ErrNotVerified
+		}
+	}
+	return res, nil
+}
+
+/**
+ * Repairs damaged sections as the scan finds them, in a single pass. Clean
+ * sections before the first damaged one are copied from the data file once
+ * the output is created.
+ */
+func (fs *fileSet) scanAndRepair(ctx context.Context, opts *RepairOptions, create func() (io.Writer, error)) (*ScanResult, io.Writer, *decoding.RepairResult, error) {
+	diff, err := decoding.SizeDiff(&fs.meta, fs.data)
+	if err == filehelper.ErrUnknownSize {
+		diff = 0
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
+	salvage, bad := salvageFor(opts.Salvage, opts.KnownBad)
+	do := &decoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log), Salvage: salvage}
+
+	var damages []decoding.DamageDesc
+	var out io.Writer
+	var r *decoding.Repairer
+	start := func(section int) error {
+		var err error
+		if out, err = create(); err != nil {
+			out = nil
+			return err
+		}
+		if n := int64(section)*fs.meta.SectionSize(); n > 0 {
+			if n > fs.meta.FileSize {
+				n = fs.meta.FileSize
+			}
+			if _, err := io.Copy(out, io.NewSectionReader(fs.data, 0, n)); err != nil {
+				out = nil // nothing was repaired into it
+				return err
+			}
+		}
+		r = decoding.NewRepairer(do, &fs.meta, out, section)
+		return nil
+	}
+	err = fs.walk(ctx, do, bad, func(ev *decoding.SectionEvent) error {
+		if ev.Status == decoding.SectionFailed {
+			return ev.Err
+		}
+		if ev.Damage != nil {
+			damages = append(damages, *ev.Damage)
+			if r == nil {
+				if err := start(ev.Section); err != nil {
+					return err
+				}
+			}
+		}
+		if r == nil {
+			return nil
+		}
+		return r.Section(ev)
+	})
+
+	scan := &ScanResult{Damages: fromDamages(&fs.meta, damages), SizeDiff: diff}
+	if bad != nil {
+		for _, r := range bad.Ranges {
+			scan.Unreadable = append(scan.Unreadable, ByteRange{r.Start, r.End})
+		}
+	}
+	if r == nil && err == nil && diff != 0 {
+		// only extra bytes at the end, cut them off
+		if err = start(fs.meta.NumSections()); err != nil {
+			return scan, out, nil, err
+		}
+	}
+	if r == nil {
+		return scan, out, nil, err
+	}
+	repaired, rerr := r.Finish()
+	if err == nil {
+		err = rerr
+	}
+	return scan, out, repaired, err
+}
+
+// repairDamages repairs the damages given in opts
+func (fs *fileSet) repairDamages(ctx context.Context, opts *RepairOptions, create func() (io.Writer, error)) (io.Writer, *decoding.RepairResult, error) {
+	damages, err := toDamages(&fs.meta, opts.Damages)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff, err := decoding.SizeDiff(&fs.meta, fs.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(damages) == 0 && diff == 0 {
+		return nil, nil, nil
+	}
+
+	out, err := create()
+	if err != nil {
+		return nil, nil, err
+	}
+	salvage, _ := salvageFor(opts.Salvage, opts.KnownBad)
+	repaired, err := decoding.RepairWith(ctx, &decoding.Options{Log: logFunc(opts.Log), Salvage: salvage}, &fs.meta, out, fs.data, fs.ecc, damages)
+	return out, repaired, err
+}
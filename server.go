@@ -0,0 +1,200 @@
+package rsfileprotect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+)
+
+/**
+ * HealedHeader lists sections rebuilt from ecc chunks, e.g. "1,3". As a
+ * header it holds those rebuilt before a response started, as a trailer all
+ * of them.
+ */
+const HealedHeader = "X-Healed-Sections"
+
+type ServerOptions struct {
+	Salvage 	*SalvageOptions // off if nil
+	Log 		func(LogEvent) // receives damaged chunks, healed responses and failed requests, silent if nil
+}
+
+type fileServer struct {
+	root 	string
+	opts 	ServerOptions
+	logger 	logging.Logger
+}
+
+/**
+ * FileServer returns a read-only handler serving the protected files under
+ * root, those with an ecc file next to them, with support for Range requests.
+ * Other files, sidecars and directories are not found. Responses are read
+ * through a Reader and healed from the ecc file section by section as they
+ * are sent. The first section of a response is checked before it starts,
+ * so that it gives a 500 if that section is damaged beyond repair, and
+ * sections it needed rebuilt are listed in HealedHeader. A later section
+ * beyond repair aborts the connection, so that clients see a broken
+ * transfer instead of a short or wrong body. Every section rebuilt for a
+ * response is listed once more in the HealedHeader trailer, so bodies are
+ * sent chunked rather than with a Content-Length.
+ */
+func FileServer(root string, opts ServerOptions) http.Handler {
+	return &fileServer{root: root, opts: opts, logger: logging.New(logFunc(opts.Log))}
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + req.URL.Path)
+	file := filepath.Join(s.root, filepath.FromSlash(name))
+	fi, err := os.Stat(file)
+	if err != nil || !fi.Mode().IsRegular() || !exists(EccPathFor(file)) {
+		http.NotFound(w, req)
+		return
+	}
+
+	// the default cache keeps the section being sent, ServeContent reads it in smaller pieces
+	r, err := Open(file, ReaderOptions{Salvage: s.opts.Salvage, Log: s.opts.Log})
+	if err != nil {
+		s.fail(w, name, err)
+		return
+	}
+	defer r.Close()
+	start, length := checkedRange(req, r.Size())
+	if req.Method == http.MethodGet && length > 0 { // HEAD sends no data, nothing to check
+		// reading a byte heals its whole section and keeps it for the response
+		if _, err := r.ReadAt(make([]byte, 1), start); err != nil {
+			s.fail(w, name, err)
+			return
+		}
+	}
+	healed := sectionList(r.Repaired())
+	if healed != "" {
+		w.Header().Set(HealedHeader, healed)
+	}
+	// sections after the first are only known to be rebuilt once the body is out
+	w.Header().Set("Trailer", HealedHeader)
+
+	body := &bodyReader{r: r, start: start}
+	http.ServeContent(trailerWriter{w}, req, fi.Name(), fi.ModTime(), io.NewSectionReader(body, 0, r.Size()))
+	if all := sectionList(r.Repaired()); all != "" {
+		w.Header().Set(HealedHeader, all)
+		s.logger.Info("Response healed", logging.F("path", name), logging.F("sections", all))
+	}
+	if body.err != nil {
+		// the status line is out, all that is left is to break the transfer
+		s.logger.Error("Response aborted", logging.F("path", name), logging.Err(body.err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+/**
+ * bodyReader remembers the first error reading a response, which
+ * ServeContent drops once the body has started. Reads before start, like
+ * the one ServeContent sniffs the content type with, are not part of it.
+ */
+type bodyReader struct {
+	r 		*Reader
+	start 	int64
+	err 	error
+}
+
+func (b *bodyReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := b.r.ReadAt(p, off)
+	if err != nil && err != io.EOF && off >= b.start && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+/**
+ * trailerWriter drops the Content-Length ServeContent sets for a body, which
+ * has to be sent chunked for the trailer to follow it
+ */
+type trailerWriter struct {
+	http.ResponseWriter
+}
+
+func (t trailerWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		t.Header().Del("Content-Length")
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// sectionList returns the sections of damages as "1,3"
+func sectionList(damages []Damage) string {
+	var sections []string
+	for _, d := range damages {
+		sections = append(sections, strconv.Itoa(d.Section))
+	}
+	return strings.Join(sections, ",")
+}
+
+func (s *fileServer) fail(w http.ResponseWriter, name string, err error) {
+	s.logger.Error("Request failed", logging.F("path", name), logging.Err(err))
+	var lost *UnrecoverableError
+	if errors.As(err, &lost) {
+		http.Error(w, fmt.Sprintf("section %d is damaged beyond repair", lost.Sections[0]), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "file cannot be read", http.StatusInternalServerError)
+}
+
+/**
+ * checkedRange returns the bytes a response to req may send. It knows single
+ * ranges; for anything else, including ranges that If-Range may turn into a
+ * full response, it returns the whole file. The first section of them is
+ * checked before the response starts.
+ */
+func checkedRange(req *http.Request, size int64) (start, length int64) {
+	spec := req.Header.Get("Range")
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") || req.Header.Get("If-Range") != "" {
+		return 0, size
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, size
+	}
+	first, last := strings.TrimSpace(spec[len("bytes="):i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0 // not satisfiable, nothing is sent
+	}
+	end := size
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, size
+		}
+		if e+1 < end {
+			end = e+1
+		}
+	}
+	return start, end - start
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
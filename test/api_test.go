@@ -0,0 +1,154 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+)
+
+func TestAPI(t *testing.T) {
+	dir, fn, en, cn := makeFileAndNames(t, 40960*6 + 77)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	orig, _ := ioutil.ReadFile(fn)
+
+	if err := rsfileprotect.Protect(ctx, fn, rsfileprotect.ProtectOptions{Level: 11}); !errors.Is(err, rsfileprotect.ErrInvalidOptions) {
+		t.Fatalf("Level 11 returned %v", err)
+	}
+	var reports int
+	opts := rsfileprotect.ProtectOptions{EccPath: en, Level: 2, Creator: "api test",
+		Regions: []rsfileprotect.Region{{Start: 0, End: 4096, Level: 4}},
+		Progress: func(rsfileprotect.Progress) { reports++ }}
+	if err := rsfileprotect.Protect(ctx, fn, opts); err != nil {
+		t.Fatal(err)
+	}
+	if reports != 8 {
+		t.Fatalf("Got %d progress reports for 7 sections", reports)
+	}
+	if _, err := os.Stat(cn); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := rsfileprotect.ReadInfo(en)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FileSize != 40960*6 + 77 || info.Creator != "api test" || info.Sections != 7 || info.EccChunks != 4 + 6*2 ||
+		len(info.Regions) != 1 || info.Regions[0].Level != 4 {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	res, err := rsfileprotect.Scan(ctx, fn, rsfileprotect.ScanOptions{EccPath: en})
+	if err != nil || !res.Clean() {
+		t.Fatalf("Scan of a clean file returned %+v, %v", res, err)
+	}
+
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{100, 4096*2 + 1, 40960*3 + 5, 40960*3 + 4096 + 5, 40960*3 + 8192 + 5})
+	f.Close()
+	res, err = rsfileprotect.Scan(ctx, fn, rsfileprotect.ScanOptions{EccPath: en})
+	if err != nil || res.Clean() || res.Repairable() || len(res.Damages) != 2 {
+		t.Fatalf("Unexpected scan result %+v, %v", res, err)
+	}
+	if d := res.Damages[0]; d.Section != 0 || !equals(d.Data, []int{0, 2}) || !d.Repairable || len(d.Chunks) != 2 {
+		t.Fatalf("Unexpected damage %+v", d)
+	}
+	if d := res.Damages[1]; d.Section != 3 || !equals(d.Data, []int{0, 1, 2}) || d.Repairable {
+		t.Fatalf("Unexpected damage %+v", d)
+	}
+
+	out := fn + ".repaired"
+	rr, err := rsfileprotect.Repair(ctx, fn, out, rsfileprotect.RepairOptions{EccPath: en})
+	var unrecoverable *rsfileprotect.UnrecoverableError
+	if !errors.As(err, &unrecoverable) || !equals(unrecoverable.Sections, []int{3}) || !equals(rr.Repaired, []int{0}) {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+
+	// given damages are repaired without scanning, leaving section 3 as it is
+	rr, err = rsfileprotect.Repair(ctx, fn, out, rsfileprotect.RepairOptions{EccPath: en, Verify: true,
+		Damages: []rsfileprotect.Damage{{Section: 0, Data: []int{0, 2}}}})
+	if err != rsfileprotect.ErrNotVerified || rr.Scan != nil || !equals(rr.Repaired, []int{0}) {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+	if _, err := rsfileprotect.Repair(ctx, fn, out, rsfileprotect.RepairOptions{EccPath: en,
+		Damages: []rsfileprotect.Damage{{Section: 7, Data: []int{0}}}}); !errors.Is(err, rsfileprotect.ErrInvalidOptions) {
+		t.Fatalf("Out of range damage returned %v", err)
+	}
+
+	// within the level of the file
+	ioutil.WriteFile(fn, orig, 0644)
+	f, _ = os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{100, 40960*3 + 5, 40960*3 + 4096 + 5})
+	f.Close()
+	rr, err = rsfileprotect.Repair(ctx, fn, out, rsfileprotect.RepairOptions{EccPath: en, Verify: true})
+	if err != nil || !rr.Written || !rr.Verified || !equals(rr.Repaired, []int{0, 3}) {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+	if repaired, _ := ioutil.ReadFile(out); !bytes.Equal(repaired, orig) {
+		t.Fatal("Repaired file differs from the original")
+	}
+
+	// an output that cannot be created fails the repair, whether damages are given or found on the way
+	missing := filepath.Join(dir, "missing", "out")
+	for _, damages := range [][]rsfileprotect.Damage{nil, {{Section: 0, Data: []int{0}}}} {
+		rr, err := rsfileprotect.Repair(ctx, fn, missing, rsfileprotect.RepairOptions{EccPath: en, Damages: damages})
+		if !os.IsNotExist(err) || rr != nil {
+			t.Fatalf("Repair into a missing directory returned %+v, %v", rr, err)
+		}
+	}
+
+	// given damages need not be sorted nor free of duplicates
+	rr, err = rsfileprotect.Repair(ctx, fn, out, rsfileprotect.RepairOptions{EccPath: en, Verify: true,
+		Damages: []rsfileprotect.Damage{{Section: 3, Data: []int{1, 0, 1}}, {Section: 0, Data: []int{0}}, {Section: 3, Data: []int{0}}}})
+	if err != nil || !rr.Verified || !equals(rr.Repaired, []int{0, 3}) {
+		t.Fatalf("Repair of unsorted damages returned %+v, %v", rr, err)
+	}
+	if repaired, _ := ioutil.ReadFile(out); !bytes.Equal(repaired, orig) {
+		t.Fatal("Repaired file differs from the original")
+	}
+
+	// ecc files that are not ones
+	ioutil.WriteFile(en, make([]byte, 64), 0644)
+	if _, err := rsfileprotect.ReadInfo(en); !errors.Is(err, rsfileprotect.ErrIncomplete) {
+		t.Fatalf("Zero header returned %v", err)
+	}
+	ioutil.WriteFile(en, []byte("short"), 0644)
+	if _, err := rsfileprotect.Scan(ctx, fn, rsfileprotect.ScanOptions{EccPath: en}); !errors.Is(err, rsfileprotect.ErrBadHeader) {
+		t.Fatalf("Truncated header returned %v", err)
+	}
+}
+
+func TestAPICancel(t *testing.T) {
+	dir, fn, en, _ := makeFileAndNames(t, 40960*6)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := rsfileprotect.ProtectOptions{EccPath: en, Progress: func(p rsfileprotect.Progress) {
+		if p.Done >= 40960*2 {
+			cancel()
+		}
+	}}
+	if err := rsfileprotect.Protect(ctx, fn, opts); err != context.Canceled {
+		t.Fatalf("Canceled protect returned %v", err)
+	}
+	if _, err := rsfileprotect.ReadInfo(en); !errors.Is(err, rsfileprotect.ErrIncomplete) {
+		t.Fatalf("Reading an interrupted ecc file returned %v", err)
+	}
+
+	opts.Resume = true
+	opts.Progress = nil
+	if err := rsfileprotect.Protect(context.Background(), fn, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rsfileprotect.CheckpointPathFor(en)); !os.IsNotExist(err) {
+		t.Fatal("Checkpoint left behind")
+	}
+	if res, err := rsfileprotect.Scan(context.Background(), fn, rsfileprotect.ScanOptions{EccPath: en}); err != nil || !res.Clean() {
+		t.Fatalf("Scan of resumed encoding returned %+v, %v", res, err)
+	}
+}
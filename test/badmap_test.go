@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+const ddrescueMap = `# Mapfile. Created by GNU ddrescue version 1.25
+# Command line: ddrescue /dev/sdb disk.img disk.map
+# Start time:   2021-01-25 10:20:35
+# current_pos  current_status  current_pass
+0x00010000     +               1
+#      pos        size  status
+0x00000000  0x00000400  +
+0x00000400  0x00000200  -
+0x00000600  0x00001A00  +
+0x00002000  0x00000800  *
+0x00002800  0x00000800  /
+0x00003000  0x0000D000  +
+`
+
+func TestReadDdrescue(t *testing.T) {
+	var m filehelper.BadMap
+	if err := m.ReadDdrescue(strings.NewReader(ddrescueMap)); err != nil {
+		t.Fatal(err)
+	}
+	want := []filehelper.ByteRange{{Start: 0x400, End: 0x600}, {Start: 0x2000, End: 0x3000}}
+	if len(m.Ranges) != 2 || m.Ranges[0] != want[0] || m.Ranges[1] != want[1] {
+		t.Fatalf("Ranges %v, expected %v", m.Ranges, want)
+	}
+
+	if err := m.ReadDdrescue(strings.NewReader("0x0 +\n0x0 0xZZ -\n")); err == nil {
+		t.Error("Malformed mapfile accepted")
+	}
+}
+
+func TestReadBadblocks(t *testing.T) {
+	var m filehelper.BadMap
+	if err := m.ReadBadblocks(strings.NewReader("3\n4\n\n10\n"), 1024); err != nil {
+		t.Fatal(err)
+	}
+	want := []filehelper.ByteRange{{Start: 3072, End: 5120}, {Start: 10240, End: 11264}}
+	if len(m.Ranges) != 2 || m.Ranges[0] != want[0] || m.Ranges[1] != want[1] {
+		t.Fatalf("Ranges %v, expected %v", m.Ranges, want)
+	}
+	if err := m.ReadBadblocks(strings.NewReader("12a\n"), 1024); err == nil {
+		t.Error("Malformed badblocks list accepted")
+	}
+}
+
+func TestDamageFromMap(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*3, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	var m filehelper.BadMap
+	m.Add(4096*9+4000, 4096*10+1) // spans two sections
+	m.Add(4096*10+100, 4096*10+200)
+	m.Add(4096*25, 4096*27)
+	m.Add(40960*3, 40960*4) // past end of file
+
+	damages := decoding.DamageFromMap(&meta, &m)
+	want := makeDamageArray("0|9|; 1|0|; 2|5,6|")
+	scanned := makeDamageArray("0|2|0; 2|6,8|")
+	merged := decoding.MergeDamages(scanned, damages)
+	wantMerged := makeDamageArray("0|2,9|0; 1|0|; 2|5,6,8|")
+
+	for _, c := range []struct{ has, want []decoding.DamageDesc }{{damages, want}, {merged, wantMerged}} {
+		if len(c.has) != len(c.want) {
+			t.Fatalf("Damages %v, expected %v", c.has, c.want)
+		}
+		for i := range c.want {
+			if c.has[i].Section != c.want[i].Section || !equals(c.has[i].DataDamage, c.want[i].DataDamage) || !equals(c.has[i].EccDamage, c.want[i].EccDamage) {
+				t.Fatalf("Damages %v, expected %v", c.has, c.want)
+			}
+		}
+	}
+}
+
+// unreadable sectors at different offsets of two chunks are repairable with a single ecc chunk
+func TestRepairFromSectors(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*2, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "sectors")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+
+	// what ddrescue leaves behind in an image: zeros
+	bad := &filehelper.BadMap{}
+	for _, off := range []int64{4096+512, 4096*4+2048, 40960+4096*3} {
+		file.WriteAt(make([]byte, 512), off)
+		bad.Add(off, off+512)
+	}
+	opts := &decoding.Options{Salvage: &filehelper.SalvageOptions{SectorSize: 512, Map: bad}}
+	res, err := decoding.ScanWith(context.Background(), opts, nil, file, ef, cf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damages := decoding.MergeDamages(res.Damages, decoding.DamageFromMap(&meta, bad))
+	if len(damages) != 2 || !equals(damages[0].DataDamage, []int{1, 4}) {
+		t.Fatalf("Unexpected damages %v", damages)
+	}
+
+	file.Seek(0, io.SeekStart)
+	ef.Seek(0, io.SeekStart)
+	rf, err := ioutil.TempFile(dir, "sectors.fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	repaired, err := decoding.RepairWith(context.Background(), opts, nil, rf, file, ef, damages)
+	if err != nil || !equals(repaired.Repaired, []int{0, 1}) {
+		t.Fatalf("Repaired %v, error %v", repaired.Repaired, err)
+	}
+	rContents, _ := ioutil.ReadFile(rf.Name())
+	if string(rContents) != string(contents) {
+		t.Fatal("Repaired file differs from original")
+	}
+}
+
+func TestBadblocksSizeFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	meta := types.Metadata{FileSize: 40960, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "bbsize")
+	file.Close()
+	ef.Close()
+	cf.Close()
+	bb := filepath.Join(dir, "bad.txt")
+	ioutil.WriteFile(bb, []byte("1\n"), 0644)
+
+	for _, c := range []struct{ size string; rc int }{{"1024", 2}, {"0", 1}, {"-512", 1}} {
+		cmd := exec.Command("../decoder", "s", "-data", file.Name(), "-ecc", ef.Name(), "-crc", cf.Name(), "-badblocks", bb, "-bbsize", c.size)
+		out, err := cmd.CombinedOutput()
+		if rc := cmd.ProcessState.ExitCode(); rc != c.rc {
+			t.Fatalf("-bbsize %s exited with %d, %v: %s", c.size, rc, err, out)
+		}
+	}
+}
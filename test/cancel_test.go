@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// cancelAfter returns a progress callback canceling ctx after n sections
+func cancelAfter(n int, cancel context.CancelFunc, reports *int) progress.Func {
+	return func(s progress.Stats) {
+		if s.Finished {
+			return
+		}
+		*reports++
+		if *reports == n {
+			cancel()
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*8, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "cancel")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+
+	// encoding stops after the second section
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := 0
+	ef2, _ := os.Create(filepath.Join(dir, "partial.ecc"))
+	cf2, _ := os.Create(filepath.Join(dir, "partial.crc"))
+	defer ef2.Close()
+	defer cf2.Close()
+	if err := encoding.EncodeWith(ctx, &encoding.Options{Progress: cancelAfter(2, cancel, &reports)}, meta, file, ef2, cf2); err != context.Canceled {
+		t.Fatalf("Canceled encoding returned %v", err)
+	}
+	if reports != 2 {
+		t.Fatalf("Encoded %d sections after cancellation at 2", reports)
+	}
+
+	// scanning reports the damage found before the cancellation
+	file.Seek(0, 0)
+	corruptFile(file, []int{100, 40960*5})
+	ctx, cancel = context.WithCancel(context.Background())
+	reports = 0
+	opts := &decoding.Options{Progress: cancelAfter(3, cancel, &reports)}
+	res, err := decoding.ScanWith(ctx, opts, nil, file, ef, cf, 0)
+	damages := res.Damages
+	if err != context.Canceled || len(damages) != 1 || damages[0].Section != 0 {
+		t.Fatalf("Canceled scan returned %v, %v", damages, err)
+	}
+
+	// repairs don't start on a canceled context
+	ef.Seek(0, 0)
+	file.Seek(0, 0)
+	out, _ := os.Create(filepath.Join(dir, "out"))
+	defer out.Close()
+	repaired, err := decoding.FastRepairContext(ctx, nil, out, file, ef, damages)
+	if err != context.Canceled || len(repaired.Repaired) != 0 {
+		t.Fatalf("Canceled repair returned %v, %v", repaired.Repaired, err)
+	}
+}
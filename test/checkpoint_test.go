@@ -0,0 +1,193 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/report"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// interruptEncoding encodes the first sections of file into ecc and crc, then cancels
+func interruptEncoding(t *testing.T, meta types.Metadata, file *os.File, ecc, crc string, sections int) int {
+	ef, _ := os.Create(ecc)
+	cf, _ := os.Create(crc)
+	defer ef.Close()
+	defer cf.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := 0
+	saved := -1
+	opts := &encoding.Options{Progress: cancelAfter(sections, cancel, &reports), Checkpoint: func(s int) error {
+		saved = s
+		return nil
+	}}
+	file.Seek(0, 0)
+	if err := encoding.EncodeWith(ctx, opts, meta, file, ef, cf); err != context.Canceled {
+		t.Fatalf("Canceled encoding returned %v", err)
+	}
+	return saved
+}
+
+func TestResumeEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*7 + 5000, BlockSize: 4096, NumData: 10, NumRecovery: 1,
+		Regions: []types.Region{region(40960*2, 40960*3, 4)}}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "full")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+
+	en, cn := filepath.Join(dir, "part.ecc"), filepath.Join(dir, "part.crc")
+	saved := interruptEncoding(t, meta, file, en, cn, 4)
+	if saved != 4 {
+		t.Fatalf("Checkpoint at section %d, expected 4", saved)
+	}
+
+	// partial files are marked as such
+	ef2, _ := os.OpenFile(en, os.O_RDWR, 0644)
+	cf2, _ := os.OpenFile(cn, os.O_RDWR, 0644)
+	defer ef2.Close()
+	defer cf2.Close()
+	var read types.Metadata
+	if err := filehelper.ReadMeta(ef2, &read); err != filehelper.ErrIncomplete {
+		t.Fatalf("Reading a partial ecc file returned %v", err)
+	}
+
+	// garbage after the checkpoint is dropped
+	ef2.Seek(0, 2)
+	ef2.Write([]byte("partially written section"))
+
+	file.Seek(0, 0)
+	if err := encoding.ResumeContext(context.Background(), meta, file, ef2, cf2, saved); err != nil {
+		t.Fatalf("Resuming failed: %v", err)
+	}
+	for _, pair := range [][2]string{{filepath.Join(dir, "full.ecc"), en}, {filepath.Join(dir, "full.crc"), cn}} {
+		want, _ := ioutil.ReadFile(pair[0])
+		has, _ := ioutil.ReadFile(pair[1])
+		if !bytes.Equal(want, has) {
+			t.Fatalf("Resumed %s differs from %s", pair[1], pair[0])
+		}
+	}
+}
+
+func TestCheckpointFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.ecc.partial")
+	ckpt := filehelper.Checkpoint{Sections: 12, ModTime: 1234,
+		Meta: types.Metadata{FileSize: 1 << 30, BlockSize: 4096, NumData: 10, NumRecovery: 2,
+			Regions: []types.Region{region(0, 1 << 20, 5)}, Creator: "test"}}
+	if err := filehelper.WriteCheckpoint(name, &ckpt); err != nil {
+		t.Fatal(err)
+	}
+	read, err := filehelper.ReadCheckpoint(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Sections != 12 || read.ModTime != 1234 || read.Meta.FileSize != 1 << 30 ||
+		len(read.Meta.Regions) != 1 || read.Meta.Regions[0] != ckpt.Meta.Regions[0] || read.Meta.Creator != "test" {
+		t.Fatalf("Read %+v, expected %+v", read, ckpt)
+	}
+
+	ioutil.WriteFile(name, []byte("not a checkpoint"), 0644)
+	if _, err := filehelper.ReadCheckpoint(name); err == nil {
+		t.Fatal("Malformed checkpoint accepted")
+	}
+}
+
+func TestScanFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*6, BlockSize: 4096, NumData: 10, NumRecovery: 1,
+		Regions: []types.Region{region(0, 100, 3)}}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "scan")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+	corruptFile(file, []int{100, 40960*2 + 5, 40960*4 + 4096})
+	ecc := make([]byte, 1)
+	ef.ReadAt(ecc, filehelper.HeaderSize + 4096*4)
+	ecc[0] ^= 0xff
+	ef.WriteAt(ecc, filehelper.HeaderSize + 4096*4) // section 2, after 3 ecc chunks of section 0
+
+	res, err := decoding.ScanFileFrom(context.Background(), nil, file, ef, cf, 2)
+	damages := res.Damages
+	if err != nil || res.Sections != meta.NumSections() || len(damages) != 2 || damages[0].Section != 2 || damages[1].Section != 4 {
+		t.Fatalf("Unexpected damages %+v", damages)
+	}
+	if !equals(damages[0].DataDamage, []int{0}) || !equals(damages[0].EccDamage, []int{0}) || !equals(damages[1].DataDamage, []int{1}) {
+		t.Fatalf("Unexpected damages %+v", damages)
+	}
+}
+
+func TestResumeCLI(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 40960*5 + 123)
+	defer os.RemoveAll(dir)
+	en, cn := fn+".ecc", fn+".ecc.crc"
+
+	file, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fs, _ := file.Stat()
+	meta := types.Metadata{FileSize: fs.Size(), BlockSize: 4096, NumData: 10, NumRecovery: 1, Creator: "first run"}
+	saved := interruptEncoding(t, meta, file, en, cn, 2)
+	ckpt := filehelper.Checkpoint{Meta: meta, Sections: saved, ModTime: fs.ModTime().UnixNano()}
+	if err := filehelper.WriteCheckpoint(en+".partial", &ckpt); err != nil {
+		t.Fatal(err)
+	}
+
+	if rc, output := runRsprotect(t, "info", fn); rc != 1 || !strings.Contains(string(output), "2 of 6 sections encoded") {
+		t.Fatalf("Expected exit code 1, has %d\n%s", rc, output)
+	}
+	rc, output := runRsprotect(t, "protect", fn)
+	if rc != 0 || !strings.Contains(string(output), "Resuming section=2") {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+	if _, err := os.Stat(en + ".partial"); !os.IsNotExist(err) {
+		t.Fatal("Checkpoint left behind")
+	}
+	if rc, output := runRsprotect(t, "info", fn); rc != 0 || !strings.Contains(string(output), "first run") {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+
+	// a scan resumed from a checkpoint keeps the damage found before it
+	ef, _ := os.Open(en)
+	var read types.Metadata
+	filehelper.ReadMeta(ef, &read)
+	ef.Close()
+	ckptName := filepath.Join(dir, "scan.ckpt")
+	f, _ := os.Create(ckptName)
+	report.WritePartial(f, fn, &read, []decoding.DamageDesc{{Section: 1, DataDamage: []int{3}}}, 3)
+	f.Close()
+
+	rc, output = runRsprotect(t, "verify", "-checkpoint", ckptName, fn)
+	if rc != 2 || !strings.Contains(string(output), "Data=[13]") || !strings.Contains(string(output), "Resuming scan at section 3") {
+		t.Fatalf("Expected exit code 2, has %d\n%s", rc, output)
+	}
+	if _, err := os.Stat(ckptName); !os.IsNotExist(err) {
+		t.Fatal("Scan checkpoint left behind")
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"bytes"
 	"os"
 	"os/exec"
 	"testing"
@@ -17,6 +18,7 @@ type switches struct {
 	out string
 	ddmg string
 	edmg string
+	report string
 
 	bs string // encode only
 	level string // encode only
@@ -50,6 +52,24 @@ func TestArgs(t *testing.T) {
 }
 
 
+// action a writes the repaired file given with -out
+func TestAutoRepair(t *testing.T) {
+	dir, fn, en, cn := makeFileAndNames(t, 1024*1024)
+	defer os.RemoveAll(dir)
+	assert(t, runOne(t, switches{encode: true, in: fn, ecc: en}, 0), true)
+	orig, _ := ioutil.ReadFile(fn)
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*3+5})
+	f.Close()
+
+	out := filepath.Join(dir, "test.fixed")
+	assert(t, runOne(t, switches{action: "a", in: fn, ecc: en, crc: cn, out: out}, 3), true)
+	if repaired, err := ioutil.ReadFile(out); err != nil || !bytes.Equal(repaired, orig) {
+		t.Fatalf("Repaired file differs from the original, %v", err)
+	}
+}
+
+
 func (s *switches)makeArgs() []string {
 
 	var args []string
@@ -90,6 +110,9 @@ func (s *switches)makeArgs() []string {
 		if s.edmg != "" {
 			args = append(args, "-edmg", s.edmg)
 		}
+		if s.report != "" {
+			args = append(args, "-report", s.report)
+		}
 	}
 	return args
 
@@ -167,3 +190,54 @@ func assert(t *testing.T, rc, exp bool) {
 		t.FailNow()
 	}
 }
+
+func TestExitCodes(t *testing.T) {
+	dir, fn, en, cn := makeFileAndNames(t, 1024*1024)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "test.fixed")
+	assert(t, runOne(t, switches{encode:true, in:fn, ecc:en}, 0), true)
+
+	corrupt := func(pos []int) {
+		f, err := os.OpenFile(fn, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corruptFile(f, pos)
+		f.Close()
+	}
+
+	type test struct {
+		swt switches
+		rc int
+	}
+	steps := []func(){
+		func() {},
+		func() { corrupt([]int{100}) },
+		func() { corrupt([]int{5000}) },
+	}
+	tests := [][]test{
+		{ // clean
+			{switches{action:"s", in:fn, ecc:en, crc:cn}, 0},
+			{switches{action:"a", in:fn, ecc:en, crc:cn, out:out}, 0},
+			{switches{action:"a", in:fn, ecc:en, crc:cn, out:"/"}, 0},
+		},
+		{ // one damaged chunk
+			{switches{action:"s", in:fn, ecc:en, crc:cn}, 2},
+			{switches{action:"a", in:fn, ecc:en, crc:cn, out:out}, 3},
+			{switches{action:"m", in:fn, ecc:en, crc:cn, out:out, ddmg:"[0]"}, 3},
+			{switches{action:"a", in:fn, ecc:en, crc:cn, out:dir}, 1},
+		},
+		{ // two damaged chunks in one section, level 1
+			{switches{action:"s", in:fn, ecc:en, crc:cn}, 4},
+			{switches{action:"a", in:fn, ecc:en, crc:cn, out:out}, 4},
+		},
+	}
+
+	for i, step := range steps {
+		step()
+		for _, c := range tests[i] {
+			assert(t, runOne(t, c.swt, c.rc), true)
+		}
+	}
+	assert(t, runOne(t, switches{action:"x", in:fn, ecc:en, crc:cn}, 1), true)
+}
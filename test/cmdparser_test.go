@@ -4,9 +4,9 @@ import (
 	"testing"
 	"strings"
 	"strconv"
-	"alexhalogen/rsfileprotect/internal/types"
-	"alexhalogen/rsfileprotect/internal/decoding"
-	"alexhalogen/rsfileprotect/internal/cmdparser"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/cmdparser"
 	"fmt"
 	"math/rand"
 	"time"
@@ -207,3 +207,54 @@ func csvToArr(line string) []int {
 	}
 	return ret
 }
+
+func TestChunkList(t *testing.T) {
+	meta := types.Metadata{FileSize: 4096*100+5, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	type test struct {
+		input string
+		ecc bool
+		want []int
+	}
+
+	tests := []test{
+		{input: "", want: []int{}},
+		{input: "[]", want: []int{}},
+		{input: "[1, 15, 3]", want: []int{1, 3, 15}},
+		{input: "7,3-5,4,7", want: []int{3, 4, 5, 7}},
+		{input: "0x1000-0x2fff, 100", want: []int{1, 2, 100}},
+		{input: "0x64000", want: []int{100}},
+		{input: "0x0-0x0", want: []int{0}},
+		{input: "[0x20-0x1020, 21]", ecc: true, want: []int{0, 1, 21}},
+		{input: "101", want: nil},             // past end of file
+		{input: "0x64005", want: nil},         // past end of file
+		{input: "22", ecc: true, want: nil},   // 11 sections with 2 ecc chunks
+		{input: "0x10", ecc: true, want: nil}, // header
+		{input: "5-3", want: nil},
+		{input: "3-0x5000", want: nil},
+		{input: "-1", want: nil},
+		{input: "1,,2", want: nil},
+		{input: "1-", want: nil},
+		{input: "[1,X]", want: nil},
+	}
+
+	for _, c := range tests {
+		res, err := cmdparser.ParseChunkList(c.input, &meta, c.ecc)
+		if (err != nil) != (c.want == nil) || !equals(res, c.want) {
+			t.Errorf("ParseChunkList(%q) = %v, %v; want %v", c.input, res, err, c.want)
+		}
+	}
+}
+
+func TestUnsortedDamage(t *testing.T) {
+	meta := types.Metadata{FileSize: 0, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	damages := cmdparser.CSVToDamage(&meta, []int{35, 3, 12, 3, 1}, []int{5, 0, 5})
+	want := makeDamageArray("0|1,3|0; 1|2|; 2||1; 3|5|")
+	if len(damages) != len(want) {
+		t.Fatalf("Damages %v, expected %v", damages, want)
+	}
+	for i := range want {
+		if damages[i].Section != want[i].Section || !equals(damages[i].DataDamage, want[i].DataDamage) || !equals(damages[i].EccDamage, want[i].EccDamage) {
+			t.Fatalf("Damages %v, expected %v", damages, want)
+		}
+	}
+}
@@ -6,9 +6,9 @@ import(
 	"io"
 	"path/filepath"
 	"io/ioutil"
-	"alexhalogen/rsfileprotect/internal/decoding"
-	"alexhalogen/rsfileprotect/internal/encoding"
-	"alexhalogen/rsfileprotect/internal/types"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
 )
 
 
@@ -76,9 +76,8 @@ func makeTestFiles(t *testing.T, meta types.Metadata, dir string, prefix string)
 		t.Fatal("Cannot create file for encoding")
 	}
 
-	success := encoding.Encode(meta, file, ef, cf)
-	if !success {
-		t.Error("Encoding failed")
+	if err := encoding.Encode(meta, file, ef, cf); err != nil {
+		t.Errorf("Encoding failed: %v", err)
 	}
 
 	ef.Seek(0,io.SeekStart)
@@ -115,11 +114,12 @@ func encodeThenDecode(
 		ef.Seek(0,io.SeekStart)
 	}
 
-	damages, e := decoding.ScanFile(nil, file, ef, cf) // feed in damaged file
-	if e {
-		t.Error("Generic error when decoding")
+	res, err := decoding.ScanFile(nil, file, ef, cf) // feed in damaged file
+	if err != nil {
+		t.Error(err)
 		t.FailNow()
 	}
+	damages := res.Damages
 
 	// filter damages by type
 	var dd, ed, ad []int
@@ -150,7 +150,8 @@ func encodeThenDecode(
 		if err != nil {
 			t.Fatal("Cannot create fixed file")
 		}
-		repaired, success := decoding.FastRepair(nil, rf, file, ef, damages) // feed in damaged file
+		rr, err := decoding.FastRepair(nil, rf, file, ef, damages) // feed in damaged file
+		repaired, success := rr.Repaired, err == nil
 		if !equals(repaired, expectedRepairs) {
 			t.Log(repaired)
 			t.Log(expectedRepairs)
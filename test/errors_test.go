@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestTypedErrors(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*3 + 10, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	for i := range contents {
+		contents[i] = byte(i * 13)
+	}
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(context.Background(), bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a repair used to report success when the header could not be read
+	short := &memFile{data: ecc.data[:10]}
+	if _, err := decoding.FastRepair(nil, &bytes.Buffer{}, bytes.NewReader(contents), short, nil); !errors.Is(err, filehelper.ErrBadHeader) {
+		t.Fatalf("Repair with a truncated header returned %v", err)
+	}
+	if _, err := decoding.ScanFile(nil, bytes.NewReader(contents), short, &crc); !errors.Is(err, filehelper.ErrBadHeader) {
+		t.Fatalf("Scan with a truncated header returned %v", err)
+	}
+
+	other := meta
+	other.BlockSize = 512
+	if _, err := decoding.ScanFile(&other, bytes.NewReader(contents), &ecc, &crc); !errors.Is(err, filehelper.ErrGeometryMismatch) {
+		t.Fatalf("Scan with different metadata returned %v", err)
+	}
+	shortCRC := &memFile{data: crc.data[:len(crc.data)-4]}
+	if _, err := decoding.ScanFile(nil, bytes.NewReader(contents), &ecc, shortCRC); !errors.Is(err, filehelper.ErrGeometryMismatch) {
+		t.Fatalf("Scan with a truncated crc file returned %v", err)
+	}
+
+	damaged := append([]byte{}, contents...)
+	corrupt(damaged, []int{4096*21, 4096*22})
+	res, err := decoding.ScanFile(nil, bytes.NewReader(damaged), &ecc, &crc)
+	if err != nil || len(res.Damages) != 1 || res.Damages[0].Repairable(&meta) {
+		t.Fatalf("Scan returned %+v, %v", res, err)
+	}
+	rr, err := decoding.FastRepair(nil, &bytes.Buffer{}, bytes.NewReader(damaged), &ecc, res.Damages)
+	var serr *decoding.SectionError
+	if !errors.As(err, &serr) || serr.Section != 2 || !errors.Is(err, decoding.ErrUnrecoverableSection) || !equals(rr.Failed, []int{2}) {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	dir, fn, en, _ := makeFileAndNames(t, 40960*2 + 5)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	if err := rsfileprotect.Protect(ctx, fn, rsfileprotect.ProtectOptions{EccPath: en}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960 + 1, 40960 + 4096 + 1})
+	f.Close()
+	_, err := rsfileprotect.Repair(ctx, fn, fn + ".fixed", rsfileprotect.RepairOptions{EccPath: en})
+	var lost *rsfileprotect.UnrecoverableError
+	if !errors.As(err, &lost) || !equals(lost.Sections, []int{1}) || !errors.Is(err, rsfileprotect.ErrUnrecoverableSection) {
+		t.Fatalf("Repair returned %v", err)
+	}
+
+	if err := os.Truncate(en, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rsfileprotect.Scan(ctx, fn, rsfileprotect.ScanOptions{EccPath: en}); !errors.Is(err, rsfileprotect.ErrBadHeader) {
+		t.Fatalf("Scan with a truncated ecc file returned %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// rangeReader records the lowest and highest byte read
+type rangeReader struct {
+	data []byte
+	mu sync.Mutex
+	low, high int64
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	if off < r.low {
+		r.low = off
+	}
+	if off+int64(len(p)) > r.high {
+		r.high = off + int64(len(p))
+	}
+	r.mu.Unlock()
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+func (r *rangeReader) Size() int64 {
+	return int64(len(r.data))
+}
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+	meta := types.Metadata{FileSize: 40960*6 + 123, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	for i := range contents {
+		contents[i] = byte(i * 11)
+	}
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(ctx, bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte{}, contents...)
+	corrupt(damaged, []int{40960*2 + 5000, 40960*5 + 1})
+
+	data := &rangeReader{data: damaged, low: meta.FileSize}
+	var out bytes.Buffer
+	res, err := rsfileprotect.ExtractReaderAt(ctx, data, &ecc, &crc, &out, 40960*2 + 100, 40960 + 50, rsfileprotect.ExtractOptions{})
+	if err != nil || res.Written != 40960 + 50 || !equals(res.Repaired, []int{2}) || !bytes.Equal(out.Bytes(), contents[40960*2+100:40960*3+150]) {
+		t.Fatalf("Extract returned %+v, %v", res, err)
+	}
+	if data.low != 40960*2 || data.high != 40960*4 {
+		t.Fatalf("Extract read [%d, %d), expected only sections 2 and 3", data.low, data.high)
+	}
+
+	// up to the end
+	out.Reset()
+	res, err = rsfileprotect.ExtractReaderAt(ctx, bytes.NewReader(damaged), &ecc, &crc, &out, 40960*6, -1, rsfileprotect.ExtractOptions{})
+	if err != nil || len(res.Repaired) != 0 || !bytes.Equal(out.Bytes(), contents[40960*6:]) {
+		t.Fatalf("Extract of the tail returned %+v, %v", res, err)
+	}
+	for _, r := range [][2]int64{{-1, 10}, {meta.FileSize + 1, 0}, {meta.FileSize - 10, 11}} {
+		if _, err := rsfileprotect.ExtractReaderAt(ctx, bytes.NewReader(damaged), &ecc, &crc, &out, r[0], r[1], rsfileprotect.ExtractOptions{}); !errors.Is(err, rsfileprotect.ErrInvalidOptions) {
+			t.Fatalf("Extract of %v returned %v", r, err)
+		}
+	}
+
+	// lost sections are zeros, the rest is still written
+	corrupt(damaged, []int{40960*4 + 1, 40960*4 + 4096 + 1})
+	out.Reset()
+	res, err = rsfileprotect.ExtractReaderAt(ctx, bytes.NewReader(damaged), &ecc, &crc, &out, 40960*3, 40960*3, rsfileprotect.ExtractOptions{})
+	var lost *rsfileprotect.UnrecoverableError
+	if !errors.As(err, &lost) || !equals(lost.Sections, []int{4}) || res.Written != 40960*3 || !equals(res.Repaired, []int{5}) {
+		t.Fatalf("Extract over a lost section returned %+v, %v", res, err)
+	}
+	got := out.Bytes()
+	if !bytes.Equal(got[:40960], contents[40960*3:40960*4]) || !bytes.Equal(got[40960:40960*2], make([]byte, 40960)) || !bytes.Equal(got[40960*2:], contents[40960*5:40960*6]) {
+		t.Fatal("Unexpected output around a lost section")
+	}
+}
+
+func TestExtractCommand(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 40960*3 + 10)
+	defer os.RemoveAll(dir)
+	orig, _ := ioutil.ReadFile(fn)
+	if rc, output := runRsprotect(t, "protect", "-q", fn); rc != 0 {
+		t.Fatalf("protect returned %d\n%s", rc, output)
+	}
+	out := fn + ".part"
+	if rc, output := runRsprotect(t, "extract", "-offset", "0x100", "-length", "1K", "-out", out, fn); rc != 0 {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+	if got, _ := ioutil.ReadFile(out); !bytes.Equal(got, orig[0x100:0x100+1024]) {
+		t.Fatal("Extracted bytes differ")
+	}
+
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960 + 3})
+	f.Close()
+	if rc, output := runRsprotect(t, "extract", "-offset", "40K", "-out", out, fn); rc != 3 {
+		t.Fatalf("Expected exit code 3, has %d\n%s", rc, output)
+	}
+	if got, _ := ioutil.ReadFile(out); !bytes.Equal(got, orig[40960:]) {
+		t.Fatal("Repaired range differs")
+	}
+	if rc, output := runRsprotect(t, "extract", "-offset", "1G", "-out", out, fn); rc != 1 {
+		t.Fatalf("Expected exit code 1 for an offset past the end, has %d\n%s", rc, output)
+	}
+}
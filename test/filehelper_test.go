@@ -1,11 +1,13 @@
 package test
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"path/filepath"
 	"os"
 	"testing"
-	"alexhalogen/rsfileprotect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
 )
 
 
@@ -94,3 +96,87 @@ func TestRead(t *testing.T) {
 	t.Run("bs=1,c=1", func (t *testing.T) {readAndCompare(t, basef, 1,1, contents)})
 	
 }
+
+func TestBadMap(t *testing.T) {
+	var m filehelper.BadMap
+	m.Add(100, 200)
+	m.Add(300, 400)
+	m.Add(0, 10)
+	m.Add(150, 300) // joins the first two
+	m.Add(500, 500) // empty
+
+	want := []filehelper.ByteRange{{Start: 0, End: 10}, {Start: 100, End: 400}}
+	if len(m.Ranges) != len(want) {
+		t.Fatalf("Ranges %v, expected %v", m.Ranges, want)
+	}
+	for i := range want {
+		if m.Ranges[i] != want[i] {
+			t.Fatalf("Ranges %v, expected %v", m.Ranges, want)
+		}
+	}
+	if m.Size() != 310 {
+		t.Errorf("Size %d, expected 310", m.Size())
+	}
+	if !m.Overlaps(9, 20) || m.Overlaps(10, 100) || !m.Overlaps(399, 1000) || m.Overlaps(400, 1000) {
+		t.Error("Overlaps reports wrong ranges")
+	}
+}
+
+// flakyReader fails the first reads given and every read touching the bad range
+type flakyReader struct {
+	data 	[]byte
+	fails 	int
+	bad 	filehelper.ByteRange
+	reads 	int
+}
+
+func (r *flakyReader) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	if r.fails > 0 || off < r.bad.End && off+int64(len(p)) > r.bad.Start {
+		r.fails--
+		return 0, errors.New("read error")
+	}
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+func TestSalvageChunk(t *testing.T) {
+	data := make([]byte, 8192)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	// a read failing once is retried as a whole
+	r := &flakyReader{data: data, fails: 1}
+	opts := &filehelper.SalvageOptions{Retries: 2, SectorSize: 512, Marker: 0xEE, Map: &filehelper.BadMap{}}
+	buf := make([]byte, 4096)
+	n, lost, err := filehelper.SalvageChunk(r, int64(len(data)), buf, 4096, opts)
+	if n != 4096 || lost || err != nil || r.reads != 2 || !bytes.Equal(buf, data[4096:]) {
+		t.Fatalf("Retried read returned %d, %v, %v after %d reads", n, lost, err, r.reads)
+	}
+
+	// a sector that keeps failing is lost alone and filled with the marker
+	r = &flakyReader{data: data, bad: filehelper.ByteRange{Start: 1024+100, End: 1024+101}}
+	n, lost, err = filehelper.SalvageChunk(r, int64(len(data)), buf, 0, opts)
+	if n != 4096 || !lost || err == nil {
+		t.Fatalf("Read of a bad sector returned %d, %v, %v", n, lost, err)
+	}
+	if !bytes.Equal(buf[:1024], data[:1024]) || !bytes.Equal(buf[1536:], data[1536:4096]) ||
+		!bytes.Equal(buf[1024:1536], bytes.Repeat([]byte{0xEE}, 512)) {
+		t.Fatal("Sectors read back wrong")
+	}
+	// 2 retries of the chunk, then 8 sectors with the bad one tried 3 times
+	if r.reads != 2+7+3 {
+		t.Fatalf("Salvaged with %d reads", r.reads)
+	}
+	if len(opts.Map.Ranges) != 1 || opts.Map.Ranges[0] != (filehelper.ByteRange{Start: 1024, End: 1536}) {
+		t.Fatalf("Unreadable ranges %v", opts.Map.Ranges)
+	}
+
+	// the marker stops at the end of file
+	r = &flakyReader{data: data[:4096+700], bad: filehelper.ByteRange{Start: 4096+600, End: 4096+601}}
+	buf = make([]byte, 4096)
+	n, lost, _ = filehelper.SalvageChunk(r, int64(len(r.data)), buf, 4096, &filehelper.SalvageOptions{SectorSize: 512, Marker: 0xEE})
+	if n != 700 || !lost || !bytes.Equal(buf[512:700], bytes.Repeat([]byte{0xEE}, 188)) || buf[700] != 0 {
+		t.Fatalf("Read of a bad sector at the end returned %d, %v", n, lost)
+	}
+}
@@ -0,0 +1,190 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestHealer(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*3 + 100, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(context.Background(), bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte{}, contents...)
+	corrupt(damaged, []int{40960 + 3, 40960*2 + 1, 40960*2 + 4096*5, 40960*3 + 50})
+
+	h, err := decoding.NewHealer(&decoding.Options{}, nil, bytes.NewReader(damaged), &ecc, &crc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, d, err := h.Section(0); err != nil || d != nil || !bytes.Equal(data, contents[:40960]) {
+		t.Fatalf("Clean section returned %v, %v", d, err)
+	}
+	if data, d, err := h.Section(1); err != nil || d == nil || !equals(d.DataDamage, []int{0}) || !bytes.Equal(data, contents[40960:40960*2]) {
+		t.Fatalf("Damaged section returned %v, %v", d, err)
+	}
+	// the last section is short, without padding
+	if data, d, err := h.Section(3); err != nil || d == nil || !bytes.Equal(data, contents[40960*3:]) {
+		t.Fatalf("Last section returned %v, %v", d, err)
+	}
+	var serr *decoding.SectionError
+	if _, d, err := h.Section(2); !errors.As(err, &serr) || serr.Section != 2 || d == nil {
+		t.Fatalf("Unrecoverable section returned %v, %v", d, err)
+	}
+	if _, _, err := h.Section(4); err == nil {
+		t.Fatal("Section out of range accepted")
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, fn, en, _ := makeFileAndNames(t, 40960*4 + 10)
+	defer os.RemoveAll(dir)
+	orig, _ := ioutil.ReadFile(fn)
+	if err := rsfileprotect.Protect(context.Background(), fn, rsfileprotect.ProtectOptions{EccPath: en}); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960 + 4096*2 + 1, 40960*3 + 7})
+	f.Close()
+	onDisk, _ := ioutil.ReadFile(fn)
+
+	var hook []rsfileprotect.Damage
+	var mu sync.Mutex
+	r, err := rsfileprotect.Open(fn, rsfileprotect.ReaderOptions{EccPath: en, Cache: -1, Repaired: func(d rsfileprotect.Damage) {
+		mu.Lock()
+		hook = append(hook, d)
+		mu.Unlock()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(orig)) {
+		t.Fatalf("Size is %d, expected %d", r.Size(), len(orig))
+	}
+
+	// reads crossing sections, from several goroutines
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 5000)
+			for off := int64(0); off < r.Size(); off += 3000 {
+				n, err := r.ReadAt(buf, off)
+				if (err != nil && err != io.EOF) || !bytes.Equal(buf[:n], orig[off:off+int64(n)]) {
+					t.Errorf("ReadAt at %d returned %d, %v", off, n, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := ioutil.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil || !bytes.Equal(got, orig) {
+		t.Fatalf("Reading everything returned %v", err)
+	}
+	if rep := r.Repaired(); len(rep) != 2 || len(hook) != 2 || rep[0].Section + rep[1].Section != 1 + 3 {
+		t.Fatalf("Repaired returned %+v, hook got %+v", rep, hook)
+	}
+	if after, _ := ioutil.ReadFile(fn); !bytes.Equal(after, onDisk) {
+		t.Fatal("Reader wrote to the data file")
+	}
+	if n, err := r.ReadAt(make([]byte, 10), r.Size()); n != 0 || err != io.EOF {
+		t.Fatalf("ReadAt past the end returned %d, %v", n, err)
+	}
+
+	// bytes before a lost section are returned with the error
+	f, _ = os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*2 + 5, 40960*2 + 4096 + 5})
+	f.Close()
+	r2, err := rsfileprotect.Open(fn, rsfileprotect.ReaderOptions{EccPath: en})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	buf := make([]byte, 40960)
+	n, err := r2.ReadAt(buf, 40960 + 100)
+	var lost *rsfileprotect.UnrecoverableError
+	if !errors.As(err, &lost) || !equals(lost.Sections, []int{2}) || n != 40960 - 100 || !bytes.Equal(buf[:n], orig[40960+100:40960*2]) {
+		t.Fatalf("ReadAt over a lost section returned %d, %v", n, err)
+	}
+}
+
+// badSectors fails every read touching one of its ranges, like a disk with bad sectors
+type badSectors struct {
+	*bytes.Reader
+	bad []filehelper.ByteRange
+}
+
+func (b *badSectors) ReadAt(p []byte, off int64) (int, error) {
+	for _, r := range b.bad {
+		if off < r.End && r.Start < off+int64(len(p)) {
+			return 0, errors.New("input/output error")
+		}
+	}
+	return b.Reader.ReadAt(p, off)
+}
+
+// sections are salvaged from several goroutines sharing one map of bad sectors
+func TestReaderSalvage(t *testing.T) {
+	const sections = 32
+	meta := types.Metadata{FileSize: 40960*sections, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	for i := range contents {
+		contents[i] = byte(i * 13)
+	}
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(context.Background(), bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// two chunks of every section, at different offsets so that the sectors can be rebuilt
+	data := &badSectors{Reader: bytes.NewReader(contents)}
+	for s := int64(0); s < sections; s++ {
+		for _, off := range []int64{4096*2 + 512, 4096*7 + 1024} {
+			data.bad = append(data.bad, filehelper.ByteRange{Start: s*40960 + off, End: s*40960 + off + 512})
+		}
+	}
+	r, err := rsfileprotect.NewReader(data, &ecc, &crc, rsfileprotect.ReaderOptions{Cache: -1, Salvage: &rsfileprotect.SalvageOptions{Retries: 1, SectorSize: 512}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			got := make([]byte, meta.FileSize)
+			// start at different sections, so that they are salvaged at the same time
+			for k := 0; k < sections; k++ {
+				s := int64((k+g*4) % sections)
+				if n, err := r.ReadAt(got[s*40960:(s+1)*40960], s*40960); n != 40960 || (err != nil && err != io.EOF) {
+					t.Errorf("ReadAt of section %d returned %d, %v", s, n, err)
+					return
+				}
+			}
+			if !bytes.Equal(got, contents) {
+				t.Error("Salvaged data differs from original")
+			}
+		}(g)
+	}
+	wg.Wait()
+	if rep := r.Repaired(); len(rep) != sections {
+		t.Fatalf("Repaired returned %+v", rep)
+	}
+}
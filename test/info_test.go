@@ -0,0 +1,79 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestCreationRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 12345, BlockSize: 4096, NumData: 10, NumRecovery: 2,
+		Regions: []types.Region{region(0, 100, 3)}, Created: 1600000000, Creator: "test", DataName: "data.bin"}
+	ef, _ := os.Create(filepath.Join(dir, "test.ecc"))
+	cf, _ := os.Create(filepath.Join(dir, "test.ecc.crc"))
+	defer ef.Close()
+	defer cf.Close()
+	fw := filehelper.NewFileWriter(meta, ef, cf)
+	if err := fw.WriteMeta(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.WriteTrailer(); err != nil {
+		t.Fatal(err)
+	}
+
+	var read types.Metadata
+	ef.Seek(0, 0)
+	if err := filehelper.ReadMeta(ef, &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Created != meta.Created || read.Creator != meta.Creator || read.DataName != meta.DataName || len(read.Regions) != 1 {
+		t.Fatalf("Read %+v, expected %+v", read, meta)
+	}
+
+	layout, err := filehelper.ReadLayout(ef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.TrailerVersion != 2 || layout.Size != filehelper.HeaderSize + layout.TrailerSize {
+		t.Fatalf("Unexpected layout %+v", layout)
+	}
+}
+
+func TestInfoCLI(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 300*1024)
+	defer os.RemoveAll(dir)
+	en, cn := fn+".ecc", fn+".ecc.crc"
+
+	if rc, output := runRsprotect(t, "protect", "-region", "0:64K:4", fn); rc != 0 {
+		t.Fatalf("%s", output)
+	}
+	rc, output := runRsprotect(t, "info", fn)
+	if rc != 0 {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+	for _, want := range []string{"trailer version 2", "rsprotect protect", "test.file", "Sections:      8 of 40960", "ECC chunks:    14"} {
+		if !strings.Contains(string(output), want) {
+			t.Fatalf("Missing %q in\n%s", want, output)
+		}
+	}
+	if rc, output := runRsprotect(t, "info", en); rc != 0 {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+
+	if err := os.Truncate(cn, 100); err != nil {
+		t.Fatal(err)
+	}
+	if rc, output := runRsprotect(t, "info", fn); rc != 1 || !strings.Contains(string(output), "has 100 bytes") {
+		t.Fatalf("Expected exit code 1, has %d\n%s", rc, output)
+	}
+}
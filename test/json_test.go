@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/report"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestScanResult(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*2+100, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	damages := []decoding.DamageDesc{
+		{Section: 0, DataDamage: []int{3}, EccDamage: []int{},
+			Details: []decoding.ChunkDamage{{Index: 3, Expected: 0xabc, Actual: 0xdef, Reason: decoding.DamageCRC}}},
+		{Section: 2, DataDamage: []int{0}, EccDamage: []int{0}},
+	}
+	res := report.NewScanResult("test.file", &meta, damages, -10)
+
+	if res.Clean || res.Repairable || res.ActualSize != meta.FileSize-10 || len(res.Sections) != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+	s0, s2 := res.Sections[0], res.Sections[1]
+	if !s0.Repairable || s2.Repairable || s2.Offset != 40960*2 || s2.Length != 100 {
+		t.Fatalf("Unexpected sections %+v", res.Sections)
+	}
+	c := s0.Chunks[0]
+	if c.Offset != 4096*3 || c.ExpectedCRC != "00000abc" || c.ActualCRC != "00000def" || c.Reason != "crc" {
+		t.Fatalf("Unexpected chunk %+v", c)
+	}
+	if e := s2.Chunks[1]; e.Type != "ecc" || e.Index != 2 || e.Offset != 32+4096*2 || e.Reason != "reported" {
+		t.Fatalf("Unexpected chunk %+v", e)
+	}
+
+	rep := report.NewRepairResult("out", damages, []int{0}, false)
+	if !equals(rep.Repaired, []int{0}) || !equals(rep.Failed, []int{2}) {
+		t.Fatalf("Unexpected repair result %+v", rep)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	dir, fn, en, cn := makeFileAndNames(t, 1024*1024)
+	defer os.RemoveAll(dir)
+	assert(t, runOne(t, switches{encode: true, in: fn, ecc: en}, 0), true)
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*3+5})
+	f.Close()
+
+	out := filepath.Join(dir, "test.fixed")
+	cmd := exec.Command("../decoder", "a", "-data", fn, "-ecc", en, "-crc", cn, "-out", out, "-format", "json")
+	stdout, err := cmd.Output()
+	if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 3 {
+		t.Fatalf("Expected exit code 3, has %v", err)
+	}
+
+	var result report.Output
+	if err := json.Unmarshal(stdout, &result); err != nil {
+		t.Fatalf("%v in output:\n%s", err, stdout)
+	}
+	if result.Scan == nil || result.Scan.Clean || len(result.Scan.Sections) != 1 || result.Scan.Sections[0].Section != 3 {
+		t.Fatalf("Unexpected scan result:\n%s", stdout)
+	}
+	if r := result.Repair; r == nil || !r.Success || !equals(r.Repaired, []int{3}) || r.Verified == nil || !*r.Verified {
+		t.Fatalf("Unexpected repair result:\n%s", stdout)
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/logging"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func field(fields []logging.Field, key string) interface{} {
+	for _, f := range fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+func TestLogEvents(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*3, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(context.Background(), bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	corrupt(contents, []int{40960 + 4096*3 + 7})
+
+	// nothing goes to the log package unless asked for
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	if _, err := decoding.ScanFile(nil, bytes.NewReader(contents), &ecc, &crc); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("Scan without a logger printed %q", out.String())
+	}
+
+	var events []*logging.Event
+	opts := &decoding.Options{Log: func(e *logging.Event) { events = append(events, e) }}
+	if _, err := decoding.ScanWith(context.Background(), opts, nil, bytes.NewReader(contents), &ecc, &crc, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Level != logging.Debug || events[1].Level != logging.Info {
+		t.Fatalf("Unexpected events %v", events)
+	}
+	e := events[0]
+	if field(e.Fields, "section") != 1 || field(e.Fields, "chunk") != 13 || field(e.Fields, "offset") != int64(40960 + 4096*3) {
+		t.Fatalf("Unexpected fields %v", e.Fields)
+	}
+	if s := e.String(); !strings.HasPrefix(s, "Data chunk damaged section=1 chunk=13 offset=53248 crc=") {
+		t.Fatalf("Event printed as %q", s)
+	}
+
+	logging.Std(logging.Info)(e)
+	if out.Len() != 0 {
+		t.Fatalf("Debug event printed at level info: %q", out.String())
+	}
+	logging.Std(logging.Debug)(e)
+	if !strings.Contains(out.String(), "Data chunk damaged") {
+		t.Fatalf("Debug event not printed at level debug: %q", out.String())
+	}
+
+	var apiEvents []rsfileprotect.LogEvent
+	res, err := rsfileprotect.ScanReaderAt(context.Background(), bytes.NewReader(contents), &ecc, &crc, rsfileprotect.ScanOptions{
+		Log: func(e rsfileprotect.LogEvent) { apiEvents = append(apiEvents, e) }})
+	if err != nil || len(res.Damages) != 1 || len(apiEvents) != 2 || apiEvents[0].Level != rsfileprotect.LogDebug || apiEvents[0].String() != e.String() {
+		t.Fatalf("Scan returned %+v, %v, events %v", res, err, apiEvents)
+	}
+}
+
+func TestVerbosityFlags(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 40960*2)
+	defer os.RemoveAll(dir)
+	if rc, output := runRsprotect(t, "protect", "-q", fn); rc != 0 || len(output) != 0 {
+		t.Fatalf("Quiet protect returned %d\n%s", rc, output)
+	}
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{5})
+	f.Close()
+
+	for _, c := range []struct {
+		flag string
+		chunks bool // damaged chunks are listed
+		summary bool
+	}{{"-q", false, false}, {"-v", true, true}, {"-format=text", false, true}} {
+		rc, output := runRsprotect(t, "verify", c.flag, fn)
+		if rc != 2 {
+			t.Fatalf("Expected exit code 2, has %d\n%s", rc, output)
+		}
+		if strings.Contains(string(output), "Data chunk damaged") != c.chunks || strings.Contains(string(output), "Scan finished") != c.summary {
+			t.Fatalf("Unexpected output of verify %s:\n%s", c.flag, output)
+		}
+	}
+}
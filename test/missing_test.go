@@ -0,0 +1,177 @@
+package test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// scanAndRepair runs both passes over a data file, nil meaning missing
+func scanAndRepair(t *testing.T, dir string, file, ef, cf *os.File) ([]byte, []int, bool) {
+	var data io.ReaderAt // a nil *os.File would be a file that fails to read
+	if file != nil {
+		file.Seek(0, io.SeekStart)
+		data = file
+	}
+	ef.Seek(0, io.SeekStart)
+	cf.Seek(0, io.SeekStart)
+
+	res, err := decoding.ScanFile(nil, data, ef, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file != nil {
+		file.Seek(0, io.SeekStart)
+	}
+	ef.Seek(0, io.SeekStart)
+	rf, err := os.Create(filepath.Join(dir, "missing.fixed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	repaired, err := decoding.FastRepair(nil, rf, data, ef, res.Damages)
+	success := err == nil
+
+	rContents, err := ioutil.ReadFile(rf.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rContents, repaired.Repaired, success
+}
+
+func TestRebuildMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*5+4099, BlockSize: 4096, NumData: 10, NumRecovery: 10}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "missing")
+	defer ef.Close()
+	defer cf.Close()
+	file.Close()
+
+	rContents, repaired, success := scanAndRepair(t, dir, nil, ef, cf)
+	if !success || !equals(repaired, []int{0, 1, 2, 3, 4, 5}) {
+		t.Fatalf("Repaired %v, success %v", repaired, success)
+	}
+	if string(rContents) != string(contents) {
+		t.Fatal("Rebuilt file differs from original")
+	}
+}
+
+func TestRebuildTruncatedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("rs=10-10", func(t *testing.T) {
+		meta := types.Metadata{FileSize: 40960*5+4099, BlockSize: 4096, NumData: 10, NumRecovery: 10}
+		contents, file, ef, cf := makeTestFiles(t, meta, dir, "truncated")
+		defer file.Close()
+		defer ef.Close()
+		defer cf.Close()
+		file.Truncate(40960+1000)
+
+		rContents, repaired, success := scanAndRepair(t, dir, file, ef, cf)
+		if !success || !equals(repaired, []int{1, 2, 3, 4, 5}) {
+			t.Fatalf("Repaired %v, success %v", repaired, success)
+		}
+		if string(rContents) != string(contents) {
+			t.Fatal("Rebuilt file differs from original")
+		}
+	})
+
+	t.Run("rs=10-2", func(t *testing.T) {
+		meta := types.Metadata{FileSize: 40960*5+4099, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+		contents, file, ef, cf := makeTestFiles(t, meta, dir, "truncated")
+		defer file.Close()
+		defer ef.Close()
+		defer cf.Close()
+		file.Truncate(40960*5 + 1000) // only the two chunks of the last section are lost
+
+		rContents, repaired, success := scanAndRepair(t, dir, file, ef, cf)
+		if !success || !equals(repaired, []int{5}) {
+			t.Fatalf("Repaired %v, success %v", repaired, success)
+		}
+		if string(rContents) != string(contents) {
+			t.Fatal("Rebuilt file differs from original")
+		}
+
+		file.Truncate(40960*3 + 4096*9) // section 4 is lost entirely
+		rContents, repaired, success = scanAndRepair(t, dir, file, ef, cf)
+		if success || !equals(repaired, []int{3, 5}) {
+			t.Fatalf("Repaired %v, success %v", repaired, success)
+		}
+		if len(rContents) != len(contents) || string(rContents[:40960*4]) != string(contents[:40960*4]) {
+			t.Fatal("Repairable sections differ from original")
+		}
+	})
+}
+
+func TestExtendedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, extra := range []int64{100, 4096*3} {
+		meta := types.Metadata{FileSize: 40960*2+4099, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+		contents, file, ef, cf := makeTestFiles(t, meta, dir, "extended")
+		file.Truncate(meta.FileSize + extra)
+		corruptFile(file, []int{40960*2+1})
+
+		if diff, err := decoding.SizeDiff(&meta, file); err != nil || diff != extra {
+			t.Fatalf("Size difference %d, expected %d", diff, extra)
+		}
+
+		rContents, repaired, success := scanAndRepair(t, dir, file, ef, cf)
+		if !success || !equals(repaired, []int{2}) {
+			t.Fatalf("Repaired %v, success %v", repaired, success)
+		}
+		if string(rContents) != string(contents) {
+			t.Fatal("Repaired file differs from original")
+		}
+		file.Close()
+		ef.Close()
+		cf.Close()
+	}
+}
+
+func TestUnreadableDataFile(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*2+4099, BlockSize: 4096, NumData: 10, NumRecovery: 10}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "unreadable")
+	defer ef.Close()
+	defer cf.Close()
+	file.Close()
+
+	// every read of a directory fails with EISDIR instead of EOF
+	df, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+
+	rContents, repaired, success := scanAndRepair(t, dir, df, ef, cf)
+	if !success || !equals(repaired, []int{0, 1, 2}) {
+		t.Fatalf("Repaired %v, success %v", repaired, success)
+	}
+	if string(rContents) != string(contents) {
+		t.Fatal("Rebuilt file differs from original")
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestLegacyPadding(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the last section holds 4 of 10 data chunks
+	meta := types.Metadata{FileSize: 40960*2 + 4096*3 + 100, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "padding")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+	corruptFile(file, []int{10, 40960*2 + 4096 + 7})
+	damages := []decoding.DamageDesc{{Section: 0, DataDamage: []int{0}}, {Section: 2, DataDamage: []int{1}}}
+
+	repair := func(name string) (*decoding.RepairResult, error, []byte) {
+		file.Seek(0, io.SeekStart)
+		ef.Seek(0, io.SeekStart)
+		rf, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rf.Close()
+		repaired, err := decoding.FastRepair(nil, rf, file, ef, damages)
+		rContents, _ := ioutil.ReadFile(rf.Name())
+		return repaired, err, rContents
+	}
+
+	var fmeta types.Metadata
+	if err := filehelper.ReadMeta(ef, &fmeta); err != nil || fmeta.LegacyPadding {
+		t.Fatalf("New ecc file read as legacy %v, error %v", fmeta.LegacyPadding, err)
+	}
+	repaired, err, rContents := repair("padding.fixed")
+	if err != nil || !equals(repaired.Repaired, []int{0, 2}) || !bytes.Equal(rContents, contents) {
+		t.Fatalf("Repaired %v, error %v", repaired.Repaired, err)
+	}
+
+	// files of older versions end without a trailer, their last section is not rebuilt
+	fi, _ := ef.Stat()
+	ef.Truncate(fi.Size() - int64(len(trailerOf(t, ef))))
+	ef.Seek(0, io.SeekStart)
+	if err := filehelper.ReadMeta(ef, &fmeta); err != nil || !fmeta.LegacyPadding {
+		t.Fatalf("Ecc file without trailer read as legacy %v, error %v", fmeta.LegacyPadding, err)
+	}
+	repaired, err, rContents = repair("legacy.fixed")
+	if !errors.Is(err, decoding.ErrStalePadding) || !equals(repaired.Repaired, []int{0}) || !equals(repaired.Failed, []int{2}) ||
+		!bytes.Equal(rContents[:40960*2], contents[:40960*2]) {
+		t.Fatalf("Repaired %v, failed %v of a legacy file, error %v", repaired.Repaired, repaired.Failed, err)
+	}
+	h, err := decoding.NewHealer(nil, nil, file, ef, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.Section(2); !errors.Is(err, decoding.ErrStalePadding) {
+		t.Fatalf("Healing the last section of a legacy file returned %v", err)
+	}
+}
+
+// trailerOf returns what follows the ecc chunks of a file without regions
+func trailerOf(t *testing.T, ef *os.File) []byte {
+	fi, _ := ef.Stat()
+	var meta types.Metadata
+	ef.Seek(0, io.SeekStart)
+	if err := filehelper.ReadMeta(ef, &meta); err != nil {
+		t.Fatal(err)
+	}
+	end := filehelper.HeaderSize + int64(meta.NumSections()*int(meta.NumRecovery))*int64(meta.BlockSize)
+	trailer := make([]byte, fi.Size()-end)
+	ef.ReadAt(trailer, end)
+	return trailer
+}
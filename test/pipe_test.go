@@ -0,0 +1,101 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+)
+
+// pipe hides everything but Read, like stdin
+type pipe struct {
+	r io.Reader
+}
+
+func (p pipe) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func TestScanStream(t *testing.T) {
+	ctx := context.Background()
+	dir, fn, en, _ := makeFileAndNames(t, 40960*4 + 300)
+	defer os.RemoveAll(dir)
+	if err := rsfileprotect.Protect(ctx, fn, rsfileprotect.ProtectOptions{EccPath: en}); err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := ioutil.ReadFile(fn)
+	opts := rsfileprotect.ScanOptions{EccPath: en}
+
+	res, err := rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(orig)}, opts)
+	if err != nil || !res.Clean() {
+		t.Fatalf("Scan of a clean stream returned %+v, %v", res, err)
+	}
+
+	damaged := append([]byte{}, orig...)
+	corrupt(damaged, []int{40960 + 5, 40960*3 + 4096*2})
+	res, err = rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(damaged)}, opts)
+	if err != nil || len(res.Damages) != 2 || res.Damages[0].Section != 1 || res.Damages[1].Section != 3 || res.SizeDiff != 0 || !res.Repairable() {
+		t.Fatalf("Scan of a damaged stream returned %+v, %v", res, err)
+	}
+	if len(res.Damages[1].Data) != 1 || res.Damages[1].Data[0] != 2 {
+		t.Fatalf("Damaged chunk reported as %v", res.Damages[1].Data)
+	}
+
+	res, err = rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(orig[:40960*2 + 100])}, opts)
+	if err != nil || res.SizeDiff != 40960*2 + 100 - int64(len(orig)) || res.Clean() {
+		t.Fatalf("Scan of a truncated stream returned %+v, %v", res, err)
+	}
+
+	res, err = rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(append(orig, make([]byte, 5000)...))}, opts)
+	if err != nil || res.SizeDiff != 5000 || len(res.Damages) != 0 {
+		t.Fatalf("Scan of a stream with extra bytes returned %+v, %v", res, err)
+	}
+
+	if _, err := rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(orig)}, rsfileprotect.ScanOptions{}); !errors.Is(err, rsfileprotect.ErrInvalidOptions) {
+		t.Fatalf("Scan of a stream without ecc file returned %v", err)
+	}
+	if _, err := rsfileprotect.ScanStream(ctx, pipe{bytes.NewReader(orig)}, rsfileprotect.ScanOptions{EccPath: en, Salvage: &rsfileprotect.SalvageOptions{}}); !errors.Is(err, rsfileprotect.ErrInvalidOptions) {
+		t.Fatalf("Salvaging a stream returned %v", err)
+	}
+}
+
+func TestVerifyStdin(t *testing.T) {
+	dir, fn, en, _ := makeFileAndNames(t, 40960*3 + 77)
+	defer os.RemoveAll(dir)
+	if rc, output := runRsprotect(t, "protect", "-ecc", en, fn); rc != 0 {
+		t.Fatalf("protect failed with %d\n%s", rc, output)
+	}
+	orig, _ := ioutil.ReadFile(fn)
+
+	verify := func(data []byte, args ...string) (int, string) {
+		cmd := exec.Command("../rsprotect", append([]string{"verify"}, args...)...)
+		cmd.Stdin = bytes.NewReader(data)
+		output, _ := cmd.CombinedOutput()
+		return cmd.ProcessState.ExitCode(), string(output)
+	}
+	if rc, output := verify(orig, "-ecc", en, "-"); rc != 0 {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+	damaged := append([]byte{}, orig...)
+	corrupt(damaged, []int{4096*12 + 1})
+	if rc, output := verify(damaged, "-ecc", en, "-"); rc != 2 || !strings.Contains(output, "Data=[12]") {
+		t.Fatalf("Expected exit code 2 and chunk 12, has %d\n%s", rc, output)
+	}
+	if rc, output := verify(orig[:100], "-ecc", en, "-"); rc != 4 || !strings.Contains(output, "Size=100") {
+		t.Fatalf("Expected exit code 4 and size 100, has %d\n%s", rc, output)
+	}
+	for _, args := range [][]string{{"-"}, {"-ecc", en, "-salvage", "-"}, {"-ecc", en, "-checkpoint", fn + ".ckpt", "-"}} {
+		if rc, output := verify(orig, args...); rc != 1 {
+			t.Fatalf("verify %v expected exit code 1, has %d\n%s", args, rc, output)
+		}
+	}
+	if rc, output := runRsprotect(t, "repair", "-ecc", en, "-"); rc != 1 {
+		t.Fatalf("repair of stdin expected exit code 1, has %d\n%s", rc, output)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/progress"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// checkProgress verifies that reports advance steadily and end with one final report
+func checkProgress(t *testing.T, reports []progress.Stats, total int64, sections int) {
+	if len(reports) != sections+1 {
+		t.Fatalf("Got %d reports for %d sections", len(reports), sections)
+	}
+	var done int64
+	for i, r := range reports {
+		if r.Total != total || r.Done < done || r.Finished != (i == len(reports)-1) {
+			t.Fatalf("Unexpected report %d: %+v", i, r)
+		}
+		done = r.Done
+	}
+	if done != total {
+		t.Fatalf("Finished at %d of %d bytes", done, total)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var reports []progress.Stats
+	record := func(s progress.Stats) {
+		reports = append(reports, s)
+	}
+
+	meta := types.Metadata{FileSize: 40960*5 + 100, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "progress")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+	// encoding again writes the same chunks
+	if err := encoding.EncodeWith(context.Background(), &encoding.Options{Progress: record}, meta, file, ef, cf); err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	checkProgress(t, reports, meta.FileSize, 6)
+
+	reports = nil
+	file.Seek(0, io.SeekStart)
+	ef.Seek(0, io.SeekStart)
+	cf.Seek(0, io.SeekStart)
+	if _, err := decoding.ScanWith(context.Background(), &decoding.Options{Progress: record}, nil, file, ef, cf, 0); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	checkProgress(t, reports, meta.FileSize, 6)
+}
+
+// time spent in the callback of a walk is not coding time
+func TestWalkPhases(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*3, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(context.Background(), bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var last progress.Stats
+	opts := &decoding.Options{Progress: func(s progress.Stats) { last = s }}
+	err := decoding.Walk(context.Background(), opts, nil, bytes.NewReader(contents), &ecc, &crc, 0, func(*decoding.SectionEvent) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil || !last.Finished {
+		t.Fatalf("Walk returned %v, last report %+v", err, last)
+	}
+	if last.Coding >= 60*time.Millisecond || last.IO < 60*time.Millisecond {
+		t.Fatalf("Time in the callback counted as coding: %+v", last)
+	}
+}
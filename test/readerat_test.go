@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+// memFile is an in-memory io.ReaderAt, io.WriterAt and appending io.Writer
+type memFile struct {
+	data []byte
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	m.data = append(m.data, p...)
+	return len(p), nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.data).ReadAt(p, off)
+}
+
+func (m *memFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+func TestInMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*4 + 1234, BlockSize: 4096, NumData: 10, NumRecovery: 2,
+		Regions: []types.Region{region(0, 4096, 4)}}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "mem")
+	file.Close()
+	ef.Close()
+	cf.Close()
+
+	// trailing bytes beyond FileSize are not part of the data
+	data := append(append([]byte{}, contents...), "not protected"...)
+	var ecc, crc memFile
+	if err := encoding.Encode(meta, bytes.NewReader(data), &ecc, &crc); err != nil {
+		t.Fatalf("Encoding from memory failed: %v", err)
+	}
+	for name, m := range map[string]*memFile{"mem.ecc": &ecc, "mem.crc": &crc} {
+		if want, _ := ioutil.ReadFile(filepath.Join(dir, name)); !bytes.Equal(want, m.data) {
+			t.Fatalf("In-memory %s differs from the one written to disk", name)
+		}
+	}
+
+	damaged := append([]byte{}, contents...)
+	corrupt(damaged, []int{5, 40960*2 + 4096*3})
+	res, err := decoding.ScanFile(nil, bytes.NewReader(damaged), &ecc, &crc)
+	damages := res.Damages
+	if err != nil || len(damages) != 2 || damages[0].Section != 0 || damages[1].Section != 2 {
+		t.Fatalf("Unexpected damages %+v", damages)
+	}
+	var out bytes.Buffer
+	repaired, err := decoding.FastRepair(nil, &out, bytes.NewReader(damaged), &ecc, damages)
+	if err != nil || !equals(repaired.Repaired, []int{0, 2}) || !bytes.Equal(out.Bytes(), contents) {
+		t.Fatalf("Repaired %v, error %v, output matches: %v", repaired.Repaired, err, bytes.Equal(out.Bytes(), contents))
+	}
+}
+
+func TestSharedHandles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 40960*20 + 99, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	_, file, ef, cf := makeTestFiles(t, meta, dir, "shared")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+	corruptFile(file, []int{40960*3, 40960*17 + 8000})
+
+	var wg sync.WaitGroup
+	results := make([][]decoding.DamageDesc, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := decoding.ScanWith(context.Background(), &decoding.Options{}, nil, file, ef, cf, i)
+			if err != nil {
+				t.Errorf("Scan %d failed: %v", i, err)
+			}
+			results[i] = res.Damages
+		}(i)
+	}
+	wg.Wait()
+	for i, damages := range results {
+		if len(damages) != 2 || damages[0].Section != 3 || damages[1].Section != 17 {
+			t.Fatalf("Scan from section %d found %+v", i, damages)
+		}
+	}
+}
+
+func TestReaderAtAPI(t *testing.T) {
+	ctx := context.Background()
+	contents := make([]byte, 40960*3 + 17)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
+	// protected data inside a larger archive
+	archive := append(append([]byte("header of an archive"), contents...), "and more"...)
+	section := io.NewSectionReader(bytes.NewReader(archive), 20, int64(len(contents)))
+
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(ctx, section, section.Size(), &ecc, &crc, rsfileprotect.ProtectOptions{DataName: "member"}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := rsfileprotect.ScanReaderAt(ctx, section, &ecc, &crc, rsfileprotect.ScanOptions{}); err != nil || !res.Clean() {
+		t.Fatalf("Scan returned %+v, %v", res, err)
+	}
+
+	archive[20 + 40960] ^= 0xff
+	var out memFile
+	rr, err := rsfileprotect.RepairReaderAt(ctx, section, &ecc, &crc, &out, rsfileprotect.RepairOptions{Verify: true})
+	if err != nil || !rr.Verified || !equals(rr.Repaired, []int{1}) || !bytes.Equal(out.data, contents) {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+	if _, err := rsfileprotect.RepairReaderAt(ctx, section, &ecc, &crc, &bytes.Buffer{}, rsfileprotect.RepairOptions{Verify: true}); err == nil {
+		t.Fatal("Verifying an output that cannot be read accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/cmdparser"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestRegionGeometry(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*10, BlockSize: 4096, NumData: 10, NumRecovery: 1,
+		Regions: []types.Region{region(0, 100, 3), region(40960*9 - 1, 40960*9, 2), region(40960*9 - 1, 40960*9 + 1, 4)}}
+
+	want := []int{3, 1, 1, 1, 1, 1, 1, 1, 4, 4}
+	for i, w := range want {
+		if nr := meta.RecoveryAt(i); nr != w {
+			t.Fatalf("Section %d has %d ecc chunks, expected %d", i, nr, w)
+		}
+	}
+
+	idx := 0
+	for s, w := range want {
+		if start := meta.EccChunkStart(s); start != idx {
+			t.Fatalf("Section %d starts at ecc chunk %d, expected %d", s, start, idx)
+		}
+		for j:=0; j<w; j++ {
+			if c, r := meta.EccChunkSection(idx+j); c != s || r != j {
+				t.Fatalf("Ecc chunk %d maps to %d/%d, expected %d/%d", idx+j, c, r, s, j)
+			}
+		}
+		idx += w
+	}
+}
+
+func TestRegionRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("","")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := types.Metadata{FileSize: 1024*1024, BlockSize: 4096, NumData: 10, NumRecovery: 1,
+		Regions: []types.Region{region(0, 4096, 4)}}
+	contents, file, ef, cf := makeTestFiles(t, meta, dir, "region")
+	defer file.Close()
+	defer ef.Close()
+	defer cf.Close()
+
+	var fmeta types.Metadata
+	if err := filehelper.ReadMeta(ef, &fmeta); err != nil {
+		t.Fatal(err)
+	}
+	if len(fmeta.Regions) != 1 || fmeta.Regions[0] != meta.Regions[0] {
+		t.Fatalf("Regions read back as %v", fmeta.Regions)
+	}
+	ef.Seek(0, io.SeekStart)
+
+	// 3 data + 1 ecc chunks in section 0 are still repairable, 2 in section 1 are not
+	corruptFile(file, []int{10, 4096*3+5, 4096*7, 40960+1, 40960+4096})
+	corruptFile(ef, []int{int(filehelper.HeaderSize)+4096*2})
+
+	res, err := decoding.ScanFile(nil, file, ef, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damages := res.Damages
+	if len(damages) != 2 || !equals(damages[0].DataDamage, []int{0, 3, 7}) || !equals(damages[0].EccDamage, []int{2}) {
+		t.Fatalf("Unexpected damages %v", damages)
+	}
+
+	sd, se := cmdparser.DamageToCSV(damages, &fmeta)
+	if *se != "2" {
+		t.Fatalf("Ecc damage reported as %s", *se)
+	}
+	parsed := cmdparser.CSVToDamage(&fmeta, cmdparser.CSVToIntArr("["+*sd+"]"), cmdparser.CSVToIntArr("["+*se+"]"))
+	if len(parsed) != 2 || !equals(parsed[0].EccDamage, []int{2}) || !equals(parsed[1].DataDamage, []int{0, 1}) {
+		t.Fatalf("Damages parsed back as %v", parsed)
+	}
+
+	file.Seek(0, io.SeekStart)
+	ef.Seek(0, io.SeekStart)
+	rf, err := os.Create(filepath.Join(dir, "region.fixed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	repaired, err := decoding.FastRepair(nil, rf, file, ef, damages)
+	var serr *decoding.SectionError
+	if !errors.As(err, &serr) || serr.Section != 1 || !errors.Is(err, decoding.ErrUnrecoverableSection) {
+		t.Fatalf("Repair returned %v, want section 1 unrecoverable", err)
+	}
+	if !equals(repaired.Repaired, []int{0}) || !equals(repaired.Failed, []int{1}) {
+		t.Fatalf("Repaired %v, failed %v", repaired.Repaired, repaired.Failed)
+	}
+
+	rContents, _ := ioutil.ReadFile(rf.Name())
+	for i:=0; i<40960; i++ {
+		if rContents[i] != contents[i] {
+			t.Fatalf("Repaired file content differs at offset %X", i)
+		}
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	type test struct {
+		spec string
+		want types.Region
+		ok bool
+	}
+	size := int64(10 << 20)
+	tests := []test{
+		{"0:1M:5", region(0, 1 << 20, 5), true},
+		{"-1M::5", region(size - 1<<20, size, 5), true},
+		{"0x1000:0x2000:2", region(0x1000, 0x2000, 2), true},
+		{"::1", region(0, size, 1), true},
+		{"0:1M", types.Region{}, false},
+		{"1M:0:1", types.Region{}, false},
+		{"0:1X:1", types.Region{}, false},
+		{"0:1M:0", types.Region{}, false},
+	}
+	for _, c := range tests {
+		r, err := cmdparser.ParseRegion(c.spec, size)
+		if (err == nil) != c.ok || (c.ok && r != c.want) {
+			t.Errorf("ParseRegion(%q) = %v, %v", c.spec, r, err)
+		}
+	}
+}
+
+func region(start, end int64, numRecovery uint16) types.Region {
+	return types.Region{Start: start, End: end, NumRecovery: numRecovery}
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect/internal/report"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestReportRoundTrip(t *testing.T) {
+	meta := types.Metadata{FileSize: 40960*5, BlockSize: 4096, NumData: 10, NumRecovery: 2}
+	damages := makeDamageArray("0|1,2,3,7|1; 2||0; 4|9|0,1")
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, "test.file", &meta, damages); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "data: 1-3,7,49\n") || !strings.Contains(buf.String(), "ecc: 1,4,8-9\n") {
+		t.Fatalf("Unexpected report:\n%s", buf.String())
+	}
+
+	rep, err := report.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := rep.Damages(&meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(damages) {
+		t.Fatalf("Damages read back as %v", parsed)
+	}
+	for i, d := range damages {
+		if parsed[i].Section != d.Section || !equals(parsed[i].DataDamage, d.DataDamage) || !equals(parsed[i].EccDamage, d.EccDamage) {
+			t.Fatalf("Damages read back as %v", parsed)
+		}
+	}
+
+	// same geometry, different ecc set
+	other := meta
+	other.NumRecovery = 3
+	if _, err := rep.Damages(&other); err == nil {
+		t.Error("Report accepted for a different ecc file")
+	}
+
+	for _, bad := range []string{"data: 1\n", "fingerprint: x\nsize 3\n", "fingerprint: x\ncolour: red\n"} {
+		if _, err := report.Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("Malformed report accepted: %q", bad)
+		}
+	}
+}
+
+func TestReportCLI(t *testing.T) {
+	dir, fn, en, cn := makeFileAndNames(t, 1024*1024)
+	defer os.RemoveAll(dir)
+	rn := filepath.Join(dir, "test.report")
+	out := filepath.Join(dir, "test.fixed")
+
+	contents, _ := ioutil.ReadFile(fn)
+	assert(t, runOne(t, switches{encode: true, in: fn, ecc: en, level: "2"}, 0), true)
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{5000, 4096*7})
+	f.Close()
+
+	assert(t, runOne(t, switches{action: "s", in: fn, ecc: en, crc: cn, report: rn}, 2), true)
+	rep, err := ioutil.ReadFile(rn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rep), "data: 1,7\n") {
+		t.Fatalf("Unexpected report:\n%s", rep)
+	}
+
+	// a reviewer adds a suspicious chunk by hand
+	edited := strings.Replace(string(rep), "data: 1,7", "data: 7, 0x1000-0x1fff", 1)
+	ioutil.WriteFile(rn, []byte(edited), 0644)
+	assert(t, runOne(t, switches{action: "m", in: fn, ecc: en, crc: cn, report: rn, out: out}, 3), true)
+	fixed, _ := ioutil.ReadFile(out)
+	if !bytes.Equal(fixed, contents) {
+		t.Fatal("Repaired file differs from original")
+	}
+}
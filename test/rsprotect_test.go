@@ -0,0 +1,82 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// runRsprotect runs the unified binary and returns its exit code
+func runRsprotect(t *testing.T, args ...string) (int, []byte) {
+	cmd := exec.Command("../rsprotect", args...)
+	t.Log(cmd.String())
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return 0, output
+	}
+	if exitError, ok := err.(*exec.ExitError); ok {
+		return exitError.ExitCode(), output
+	}
+	t.Fatal(err)
+	return -1, nil
+}
+
+func TestRsprotect(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 1024*1024)
+	defer os.RemoveAll(dir)
+	en, cn := fn+".ecc", fn+".ecc.crc"
+	orig, _ := ioutil.ReadFile(fn)
+
+	type test struct {
+		args []string
+		rc int
+	}
+	tests := []test{
+		{[]string{}, 1},
+		{[]string{"unknown", fn}, 1},
+		{[]string{"protect", "-level", "2", fn}, 0},
+		{[]string{"verify", fn}, 0},
+		{[]string{"info", fn}, 0},
+		{[]string{"verify", fn, "extra"}, 1},
+		{[]string{"verify", "-ecc", en + ".none", fn}, 1},
+		{[]string{"help", "repair"}, 0},
+	}
+	for _, c := range tests {
+		if rc, output := runRsprotect(t, c.args...); rc != c.rc {
+			t.Fatalf("Expected exit code %d, has %d\n%s", c.rc, rc, output)
+		}
+	}
+	if _, err := os.Stat(en); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cn); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(fn, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptFile(f, []int{100, 5000, 300000})
+	f.Close()
+
+	// flags may follow the file name
+	if rc, output := runRsprotect(t, "verify", fn, "-crc", cn); rc != 2 {
+		t.Fatalf("Expected exit code 2, has %d\n%s", rc, output)
+	}
+	if rc, output := runRsprotect(t, "repair", fn); rc != 3 {
+		t.Fatalf("Expected exit code 3, has %d\n%s", rc, output)
+	}
+	repaired, err := ioutil.ReadFile(fn + ".repaired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(orig, repaired) {
+		t.Fatal("Repaired file differs from the original")
+	}
+
+	// the legacy decoder finds the sidecars as well
+	assert(t, runOne(t, switches{action: "s", in: fn}, 2), true)
+}
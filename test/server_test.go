@@ -0,0 +1,125 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+)
+
+func get(t *testing.T, url string, header ...string) (*http.Response, []byte) {
+	req, _ := http.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestFileServer(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 40960*4 + 10)
+	defer os.RemoveAll(dir)
+	orig, _ := ioutil.ReadFile(fn)
+	if err := rsfileprotect.Protect(context.Background(), fn, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "plain"), []byte("not protected"), 0644)
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*2 + 17})
+	f.Close()
+
+	var mu sync.Mutex
+	var events []rsfileprotect.LogEvent
+	server := httptest.NewServer(rsfileprotect.FileServer(dir, rsfileprotect.ServerOptions{Log: func(e rsfileprotect.LogEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}}))
+	defer server.Close()
+	url := server.URL + "/test.file"
+
+	// section 2 is rebuilt while the response is sent, after the headers, and listed in the trailer
+	resp, body := get(t, url)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, orig) || resp.Header.Get(rsfileprotect.HealedHeader) != "" ||
+		resp.Trailer.Get(rsfileprotect.HealedHeader) != "2" {
+		t.Fatalf("GET returned %s, healed %q, trailer %q", resp.Status, resp.Header.Get(rsfileprotect.HealedHeader), resp.Trailer.Get(rsfileprotect.HealedHeader))
+	}
+	mu.Lock()
+	logged := false
+	for _, e := range events {
+		for _, f := range e.Fields {
+			logged = logged || e.Message == "Section repaired on read" && f.Key == "section" && f.Value == 2
+		}
+	}
+	mu.Unlock()
+	if !logged {
+		t.Fatalf("Section healed while sending not logged: %+v", events)
+	}
+
+	for _, c := range []struct {
+		spec string
+		start, end int
+		healed, trailer string
+	}{
+		{"bytes=100-199", 100, 200, "", ""},
+		{"bytes=40960-81920", 40960, 81921, "", "2"},
+		{"bytes=81920-81930", 81920, 81931, "2", "2"},
+		{"bytes=81930-", 81930, len(orig), "2", "2"},
+		{"bytes=-5", len(orig) - 5, len(orig), "", ""},
+	} {
+		resp, body := get(t, url, "Range", c.spec)
+		if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, orig[c.start:c.end]) ||
+			resp.Header.Get(rsfileprotect.HealedHeader) != c.healed || resp.Trailer.Get(rsfileprotect.HealedHeader) != c.trailer {
+			t.Fatalf("Range %s returned %s, %d bytes, healed %q, trailer %q", c.spec, resp.Status, len(body),
+				resp.Header.Get(rsfileprotect.HealedHeader), resp.Trailer.Get(rsfileprotect.HealedHeader))
+		}
+		if cr := fmt.Sprintf("bytes %d-%d/%d", c.start, c.end-1, len(orig)); resp.Header.Get("Content-Range") != cr {
+			t.Fatalf("Range %s returned Content-Range %q", c.spec, resp.Header.Get("Content-Range"))
+		}
+	}
+
+	for _, p := range []string{"/plain", "/test.file.ecc", "/", "/missing"} {
+		if resp, _ := get(t, server.URL + p); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("GET %s returned %s", p, resp.Status)
+		}
+	}
+	if resp, err := http.Post(url, "text/plain", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST returned %v, %v", resp, err)
+	}
+
+	// a response fails before it starts if its first section cannot be rebuilt
+	f, _ = os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*3 + 1, 40960*3 + 4096 + 1})
+	f.Close()
+	if resp, _ := get(t, url, "Range", "bytes=0-99"); resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Range before the lost section returned %s", resp.Status)
+	}
+	if resp, body := get(t, url, "Range", "bytes=122880-"); resp.StatusCode != http.StatusInternalServerError || len(body) > 100 {
+		t.Fatalf("Range starting in the lost section returned %s", resp.Status)
+	}
+	// the response has started when the lost section comes up, it is broken off
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err == nil || len(body) > 40960*3 || !bytes.Equal(body, orig[:len(body)]) {
+		t.Fatalf("GET of a file with a lost section returned %s, %d bytes, %v", resp.Status, len(body), err)
+	}
+}
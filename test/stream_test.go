@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/decoding"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+	"github.com/AlexHalogen/RSFileProtect/internal/types"
+)
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	meta := types.Metadata{FileSize: 40960*5 + 300, BlockSize: 4096, NumData: 10, NumRecovery: 1}
+	contents := make([]byte, meta.FileSize)
+	for i := range contents {
+		contents[i] = byte(i * 31)
+	}
+	var ecc, crc memFile
+	if err := rsfileprotect.ProtectReaderAt(ctx, bytes.NewReader(contents), meta.FileSize, &ecc, &crc, rsfileprotect.ProtectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte{}, contents...)
+	corrupt(damaged, []int{40960*2 + 9, 40960*4 + 4096, 40960*4 + 8192})
+
+	var statuses []decoding.SectionStatus
+	err := decoding.Walk(ctx, &decoding.Options{}, nil, bytes.NewReader(damaged), &ecc, &crc, 0, func(ev *decoding.SectionEvent) error {
+		statuses = append(statuses, ev.Status)
+		if ev.Status == decoding.SectionDamaged && (ev.Damage.Section != ev.Section || len(ev.Data) != 10 || len(ev.Ecc) != 1) {
+			t.Fatalf("Unexpected event %+v", ev)
+		}
+		return nil
+	})
+	ok, bad := decoding.SectionOK, decoding.SectionDamaged
+	if err != nil || !equalStatus(statuses, ok, ok, bad, ok, bad, ok) {
+		t.Fatalf("Walk returned %v, %v", statuses, err)
+	}
+
+	// stopping at the first damage
+	statuses = nil
+	err = decoding.Walk(ctx, &decoding.Options{}, nil, bytes.NewReader(damaged), &ecc, &crc, 0, func(ev *decoding.SectionEvent) error {
+		statuses = append(statuses, ev.Status)
+		if ev.Status == decoding.SectionDamaged {
+			return decoding.SkipRest
+		}
+		return nil
+	})
+	if err != nil || !equalStatus(statuses, ok, ok, bad) {
+		t.Fatalf("Stopped walk returned %v, %v", statuses, err)
+	}
+
+	shortCRC := &memFile{data: crc.data[:44*4]}
+	statuses = nil
+	err = decoding.Walk(ctx, &decoding.Options{}, nil, bytes.NewReader(damaged), &ecc, shortCRC, 0, func(ev *decoding.SectionEvent) error {
+		statuses = append(statuses, ev.Status)
+		return nil
+	})
+	if !errors.Is(err, filehelper.ErrGeometryMismatch) || !equalStatus(statuses, ok, ok, bad, ok, decoding.SectionFailed) {
+		t.Fatalf("Walk with a short crc file returned %v, %v", statuses, err)
+	}
+
+	// repairing from the events gives the same as a separate repair
+	var out bytes.Buffer
+	r := decoding.NewRepairer(&decoding.Options{}, &meta, &out, 0)
+	if err := decoding.Walk(ctx, &decoding.Options{}, nil, bytes.NewReader(damaged), &ecc, &crc, 0, r.Section); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := r.Finish()
+	var serr *decoding.SectionError
+	if !errors.As(err, &serr) || serr.Section != 4 || !equals(rr.Repaired, []int{2}) || !equals(rr.Failed, []int{4}) {
+		t.Fatalf("Repair from events returned %+v, %v", rr, err)
+	}
+	res, _ := decoding.ScanFile(nil, bytes.NewReader(damaged), &ecc, &crc)
+	var separate bytes.Buffer
+	decoding.FastRepair(nil, &separate, bytes.NewReader(damaged), &ecc, res.Damages)
+	if !bytes.Equal(out.Bytes(), separate.Bytes()) || !bytes.Equal(out.Bytes()[:40960*4], contents[:40960*4]) {
+		t.Fatal("Repair from events differs from a separate repair")
+	}
+	if err := r.Section(&decoding.SectionEvent{Section: 2}); err == nil {
+		t.Fatal("Repairer accepted a section out of order")
+	}
+}
+
+func equalStatus(a []decoding.SectionStatus, b ...decoding.SectionStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScanEach(t *testing.T) {
+	dir, fn, en, _ := makeFileAndNames(t, 40960*4 + 10)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	orig, _ := ioutil.ReadFile(fn)
+	if err := rsfileprotect.Protect(ctx, fn, rsfileprotect.ProtectOptions{EccPath: en}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := os.OpenFile(fn, os.O_RDWR, 0644)
+	corruptFile(f, []int{40960*2 + 100})
+	f.Close()
+	var events []rsfileprotect.SectionEvent
+	opts := rsfileprotect.ScanOptions{EccPath: en, KnownBad: []rsfileprotect.ByteRange{{Start: 40960 + 5, End: 40960 + 6}}}
+	err := rsfileprotect.ScanEach(ctx, fn, opts, func(ev rsfileprotect.SectionEvent) error {
+		events = append(events, ev)
+		if ev.Section == 2 {
+			return rsfileprotect.SkipRest
+		}
+		return nil
+	})
+	if err != nil || len(events) != 3 || events[0].Status != rsfileprotect.SectionOK {
+		t.Fatalf("ScanEach returned %+v, %v", events, err)
+	}
+	// section 1 only has a range known to be bad
+	for i, e := range events[1:] {
+		if e.Status != rsfileprotect.SectionDamaged || e.Damage.Section != i+1 || !equals(e.Damage.Data, []int{0}) || !e.Damage.Repairable {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+	}
+
+	// the clean section before the first damage is copied, the rest repaired on the way
+	fixed := fn + ".fixed"
+	rr, err := rsfileprotect.Repair(ctx, fn, fixed, rsfileprotect.RepairOptions{EccPath: en, Verify: true})
+	if err != nil || !rr.Verified || !equals(rr.Repaired, []int{2}) || len(rr.Scan.Damages) != 1 {
+		t.Fatalf("Repair returned %+v, %v", rr, err)
+	}
+	if got, _ := ioutil.ReadFile(fixed); !bytes.Equal(got, orig) {
+		t.Fatal("Repaired file differs from original")
+	}
+
+	// extra bytes are cut off without anything to rebuild
+	ioutil.WriteFile(fn, append(append([]byte{}, orig...), "extra"...), 0644)
+	rr, err = rsfileprotect.Repair(ctx, fn, fixed, rsfileprotect.RepairOptions{EccPath: en, Verify: true})
+	if err != nil || !rr.Written || !rr.Verified || len(rr.Repaired) != 0 || rr.Scan.SizeDiff != 5 {
+		t.Fatalf("Repair of a longer file returned %+v, %v", rr, err)
+	}
+	if got, _ := ioutil.ReadFile(fixed); !bytes.Equal(got, orig) {
+		t.Fatal("Extra bytes not cut off")
+	}
+}
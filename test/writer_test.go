@@ -0,0 +1,96 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"github.com/AlexHalogen/RSFileProtect"
+	"github.com/AlexHalogen/RSFileProtect/internal/filehelper"
+)
+
+func TestStreamWriter(t *testing.T) {
+	ctx := context.Background()
+	for _, size := range []int{0, 100, 40960, 40960*3 + 4097} {
+		contents := make([]byte, size)
+		for i := range contents {
+			contents[i] = byte(i * 17)
+		}
+		var data, ecc, crc memFile
+		w, err := rsfileprotect.NewWriter(&data, &ecc, &crc, rsfileprotect.ProtectOptions{Regions: []rsfileprotect.Region{{Start: 40960, End: 40961, Level: 3}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// odd sizes, so that writes end within sections and chunks
+		for rest := contents; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if _, err := rsfileprotect.ScanReaderAt(ctx, bytes.NewReader(contents), &ecc, &crc, rsfileprotect.ScanOptions{}); !errors.Is(err, rsfileprotect.ErrIncomplete) {
+			t.Fatalf("Scan before Close returned %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(contents)
+		if !bytes.Equal(data.data, contents) || w.Size() != int64(size) || w.Digest() != hex.EncodeToString(sum[:]) {
+			t.Fatalf("Writer of %d bytes passed on %d bytes, size %d, digest %s", size, len(data.data), w.Size(), w.Digest())
+		}
+		if _, err := w.Write([]byte{1}); err == nil {
+			t.Fatal("Write after Close accepted")
+		}
+
+		// the same chunks as protecting the data with its size known
+		var ecc2, crc2 memFile
+		if err := rsfileprotect.ProtectReaderAt(ctx, bytes.NewReader(contents), int64(size), &ecc2, &crc2, rsfileprotect.ProtectOptions{Regions: []rsfileprotect.Region{{Start: 40960, End: 40961, Level: 3}}}); err != nil {
+			t.Fatal(err)
+		}
+		l, _ := filehelper.ReadLayout(&ecc)
+		l2, _ := filehelper.ReadLayout(&ecc2)
+		if !bytes.Equal(crc.data, crc2.data) || !bytes.Equal(ecc.data[filehelper.HeaderSize:l.Size-l.TrailerSize], ecc2.data[filehelper.HeaderSize:l2.Size-l2.TrailerSize]) {
+			t.Fatalf("Sidecars of a stream of %d bytes differ", size)
+		}
+		res, err := rsfileprotect.ScanReaderAt(ctx, bytes.NewReader(contents), &ecc, &crc, rsfileprotect.ScanOptions{})
+		if err != nil || !res.Clean() {
+			t.Fatalf("Scan of a stream of %d bytes returned %+v, %v", size, res, err)
+		}
+	}
+}
+
+func TestProtectStdin(t *testing.T) {
+	dir, fn, _, _ := makeFileAndNames(t, 40960*2 + 77)
+	defer os.RemoveAll(dir)
+	orig, _ := ioutil.ReadFile(fn)
+	out := fn + ".stream"
+
+	cmd := exec.Command("../rsprotect", "protect", "-out", out, "-")
+	cmd.Stdin = bytes.NewReader(orig)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("protect from stdin failed: %v\n%s", err, output)
+	}
+	if got, _ := ioutil.ReadFile(out); !bytes.Equal(got, orig) {
+		t.Fatal("Data from stdin not stored")
+	}
+	info, err := rsfileprotect.ReadInfo(out + ".ecc")
+	sum := sha256.Sum256(orig)
+	if err != nil || info.FileSize != int64(len(orig)) || info.Digest != hex.EncodeToString(sum[:]) || info.DataName != "test.file.stream" {
+		t.Fatalf("ReadInfo returned %+v, %v", info, err)
+	}
+	if rc, output := runRsprotect(t, "verify", out); rc != 0 {
+		t.Fatalf("Expected exit code 0, has %d\n%s", rc, output)
+	}
+	if rc, output := runRsprotect(t, "protect", "-"); rc != 1 {
+		t.Fatalf("protect from stdin without -out returned %d\n%s", rc, output)
+	}
+}
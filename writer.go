@@ -0,0 +1,116 @@
+package rsfileprotect
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"github.com/AlexHalogen/RSFileProtect/internal/encoding"
+)
+
+/**
+ * Writer protects data as it is written, for streams whose length is not
+ * known beforehand, e.g. the output of a database dump. Everything is done
+ * in one pass: the data is passed on and the ecc and crc chunks are written
+ * as sections fill up. Close writes the last section and records the size
+ * and sha256 digest of the data in the ecc file, which is marked incomplete
+ * until then.
+ */
+type Writer struct {
+	data 	io.Writer // nil if only the sidecars are written
+	enc 	*encoding.StreamEncoder
+	files 	[]*os.File // opened by Create, the data file first
+}
+
+/**
+ * NewWriter returns a Writer passing the data on to data, which may be nil,
+ * and writing the ecc and crc chunks to ecc and crc from offset 0. Regions
+ * in opts are byte ranges from the start of the stream. The paths and Resume
+ * in opts are not used.
+ */
+func NewWriter(data io.Writer, ecc, crc io.WriterAt, opts ProtectOptions) (*Writer, error) {
+	if err := opts.check(""); err != nil {
+		return nil, err
+	}
+	eo := &encoding.Options{Progress: progressFunc(opts.Progress), Log: logFunc(opts.Log)}
+	enc, err := encoding.NewStreamEncoder(eo, opts.meta(0), ecc, crc)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{data: data, enc: enc}, nil
+}
+
+/**
+ * Create returns a Writer storing the data at path with its ecc and crc
+ * files, named as by Protect. Existing files are truncated. Resume in opts
+ * is not used.
+ */
+func Create(path string, opts ProtectOptions) (*Writer, error) {
+	if err := opts.check(path); err != nil {
+		return nil, err
+	}
+	if opts.DataName == "" {
+		opts.DataName = filepath.Base(path)
+	}
+	var files []*os.File
+	for _, name := range []string{path, opts.EccPath, opts.CRCPath} {
+		f, err := os.Create(name)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	w, err := NewWriter(files[0], files[1], files[2], opts)
+	if err != nil {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, err
+	}
+	w.files = files
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.data != nil {
+		if n, err := w.data.Write(p); err != nil {
+			return n, err
+		}
+	}
+	return w.enc.Write(p)
+}
+
+// Close completes the ecc file, after syncing the data file if opened by Create, and closes the files of Create
+func (w *Writer) Close() error {
+	var err error
+	if len(w.files) != 0 {
+		err = w.files[0].Sync()
+	}
+	if err == nil {
+		err = w.enc.Close()
+	}
+	for _, f := range w.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	w.files = nil
+	return err
+}
+
+// Size returns the number of bytes written so far
+func (w *Writer) Size() int64 {
+	return w.enc.Size()
+}
+
+// Digest returns the sha256 of the data in hex, as recorded in the ecc file by Close
+func (w *Writer) Digest() string {
+	meta := w.enc.Meta()
+	if meta.Digest == [32]byte{} {
+		return ""
+	}
+	return hex.EncodeToString(meta.Digest[:])
+}